@@ -0,0 +1,88 @@
+// Copyright 2023-2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// blind-index prints the blind index of values, to look up rows by a column
+// written with -blind-index-fields. i.e.
+//
+//	SELECT * FROM credit_card WHERE Card_Number_bidx = '<blind index>'
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"encrypter-common/blindindex"
+	"encrypter-common/keys"
+)
+
+// lookup config
+type lookupCfg struct {
+	value        string
+	keyset       string
+	masterKeyURI string
+	credentials  keys.Credentials
+}
+
+func parseFlags() lookupCfg {
+	var c lookupCfg
+	flag.StringVar(&c.value, "value", "", "Value to compute the blind index of. When empty, values are read from stdin, one per line, so they are not kept in the shell history.")
+	flag.StringVar(&c.keyset, "keyset", "", "PRF keyset filename or source used to compute the blind indexes, the same one given to -blind-index-keyset of the encrypters.")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.Parse()
+	if c.keyset == "" {
+		log.Fatal("PRF keyset filename is missing.")
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	return c
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+
+	indexer, err := blindindex.Load(ctx, cfg.keyset, cfg.masterKeyURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	printIndex := func(value string) {
+		index, err := indexer.Compute(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(index)
+	}
+
+	if cfg.value != "" {
+		printIndex(cfg.value)
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		printIndex(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright 2023-2025 Google LLC
+// Copyright 2023-2026 Google LLC
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
 // you may not use this file except in compliance with the License.
@@ -15,46 +15,180 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/tink-crypto/tink-go-gcpkms/v2/integration/gcpkms"
 	"github.com/tink-crypto/tink-go/v2/aead"
-	"github.com/tink-crypto/tink-go/v2/core/registry"
-	"github.com/tink-crypto/tink-go/v2/keyset"
 	"github.com/tink-crypto/tink-go/v2/tink"
+
+	"encrypter-common/audit"
+	"encrypter-common/blindindex"
+	"encrypter-common/charset"
+	"encrypter-common/compress"
+	"encrypter-common/dateshift"
+	"encrypter-common/dek"
+	"encrypter-common/fpe"
+	"encrypter-common/generalize"
+	"encrypter-common/keys"
+	"encrypter-common/ledger"
+	"encrypter-common/padding"
+	"encrypter-common/pii"
+	"encrypter-common/tenant"
 )
 
 var (
 	encrypter tink.HybridEncrypt
+	indexer   *blindindex.Indexer
+	fpeKeys   *fpe.KeySet
+	shifter   *dateshift.Shifter
+	tenants   *tenant.Store
+	rotator   *dek.Rotator
+	usage     *ledger.Ledger
+	auditRun  *audit.Run
 )
 
 // generator config
 type genCfg struct {
-	in           string
-	out          string
-	fields       string
-	keyset       string
-	masterKeyURI string
+	in                string
+	out               string
+	fields            string
+	keyset            string
+	keysetFingerprint string
+	masterKeyURI      string
+	credentials       keys.Credentials
+	auditLog          string
+	compress          string
+	blindIndexFields  string
+	blindIndexKeyset  string
+	fpeFields         string
+	fpeKeyset         string
+	padFields         string
+	dekPerRun         bool
+	keyLedger         string
+	keyMaxMessages    uint64
+	keyMaxAgeDays     int
+	keyLimitAction    string
+	dekBatchSize      int
+	dekManifestDir    string
+	tenantField       string
+	tenantKeysetDir   string
+	generalize        string
+	dateShiftFields   string
+	dateShiftSubject  string
+	dateShiftKeyset   string
+	dateShiftMax      string
+	dateShiftLayout   string
+	dateShiftOutput   string
+	kAnonColumns      string
+	kAnonReport       string
+	kAnonThreshold    int
+	piiAllow          string
+	piiMinLikelihood  string
+	piiSampleSize     int
+	delimiter         string
+	comment           string
+	lazyQuotes        bool
+	noHeader          bool
+	inputEncoding     string
+	strictEncoding    bool
+	validateRegex     columnRules
+	validateDate      columnRules
+	validateLuhn      string
+	quarantine        string
+	errorBudget       string
 }
 
 func parseFlags() genCfg {
 	var c genCfg
 	flag.StringVar(&c.in, "in", "", "Filename to read csv data.")
 	flag.StringVar(&c.out, "out", "", "Filename to write encrypted csv data.")
-	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of CSV header names that need to be encrypted. i.e. \"Card Type Full Name,Issuing Bank\"")
-	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data.")
-	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY'.")
+	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of CSV header names that need to be encrypted. i.e. \"Card Type Full Name,Issuing Bank\". With -no-header, a list of 1-based column numbers. i.e. \"2,3\"")
+	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data, or a keyset source: file://<path>, env://<variable> holding the keyset JSON or its base64 encoding, gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>] or vault-kv://<mount>/<path>[?version=<version>&field=<field>], i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset/versions/3\". Set KEYSET_CACHE_DIR to cache keysets fetched from Secret Manager or Vault.")
+	flag.StringVar(&c.keysetFingerprint, "keyset-fingerprint", "", "Fingerprint the keyset is pinned to, as logged by earlier runs or printed by verify-keyset. The run stops before encrypting any record if the keyset does not match, i.e. it was swapped. i.e. \"sha256:3b4c...\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set. Compressed input is always detected automatically.")
+	flag.StringVar(&c.delimiter, "delimiter", ",", "Field delimiter of the input csv, also used in the output. A single character, or \"tab\".")
+	flag.StringVar(&c.comment, "comment", "", "Lines beginning with this character are skipped and not written to the output. Disabled when empty.")
+	flag.BoolVar(&c.lazyQuotes, "lazy-quotes", false, "Allow quotes to appear in unquoted fields and non-doubled quotes in quoted fields.")
+	flag.BoolVar(&c.noHeader, "no-header", false, "The input csv has no header row. Fields must be selected by column number.")
+	flag.StringVar(&c.inputEncoding, "input-encoding", "utf-8", "Character set of the input csv, transcoded to UTF-8 before encryption. i.e. utf-8, latin1, windows-1252, shift-jis, utf-16, utf-16le, utf-16be or another IANA name. A byte order mark in the input takes precedence.")
+	flag.BoolVar(&c.strictEncoding, "strict-encoding", false, "Fail on the first byte sequence that is invalid in the input encoding instead of replacing it with U+FFFD.")
+	flag.StringVar(&c.blindIndexFields, "blind-index-fields", "", "Comma-separated list of CSV header names to write a blind index for, in an extra <name>_bidx column. The blind index is a keyed PRF of the normalized plaintext, so equality lookups work without decrypting. With -no-header, a list of 1-based column numbers. i.e. \"Card_Number\"")
+	flag.StringVar(&c.blindIndexKeyset, "blind-index-keyset", "", "PRF keyset filename used to compute blind indexes, i.e. created with the HMAC_SHA256_PRF key template. Wrapped with the master key. A file or keyset source like -keyset.")
+	flag.StringVar(&c.fpeFields, "fpe-fields", "", "Comma-separated list of CSV header names to encrypt with format-preserving encryption instead of AEAD, so values keep their length and characters. Each entry is column[:algorithm[:alphabet[:tweak]]]: algorithm ff1 (default) or ff3-1, alphabet digits (default), hex, upper-hex, lower, upper, alnum-lower, alnum-upper, alnum or chars=<characters>, tweak none (default), column, const=<text> or value=<column> to use the plaintext of an unencrypted column. Characters outside the alphabet are kept. Values must have at least a million possible values, as NIST SP 800-38G requires, i.e. 6 digits, so PINs and other values shorter than 6 digits are not supported: encrypt them with AEAD in -fields and hide their length with -pad-fields, i.e. Card_PIN:8. i.e. \"Card_Number:ff1:digits:column\"")
+	flag.StringVar(&c.fpeKeyset, "fpe-keyset", "", "PRF keyset filename the format-preserving encryption keys are derived from, i.e. created with the HMAC_SHA256_PRF key template. Wrapped with the master key. A file or keyset source like -keyset.")
+	flag.StringVar(&c.keyLedger, "key-usage-ledger", "", "Filename of a ledger counting the messages encrypted by each key ID, kept across runs. Keys are checked against -key-max-messages and -key-max-age-days. Disabled when empty.")
+	flag.Uint64Var(&c.keyMaxMessages, "key-max-messages", ledger.DefaultMaxMessages, "Number of messages a key may encrypt before it must be rotated, 0 for no limit. The default is the AES-GCM bound for random nonces.")
+	flag.IntVar(&c.keyMaxAgeDays, "key-max-age-days", 365, "Age in days past which a key must be rotated, 0 for no limit. A key is dated by the modification time of its keyset file when first recorded in the ledger.")
+	flag.StringVar(&c.keyLimitAction, "key-limit-action", "warn", "What to do when a key exceeds -key-max-messages or -key-max-age-days: warn, or refuse to encrypt.")
+	flag.BoolVar(&c.dekPerRun, "dek-per-run", false, "Encrypt with a new data keyset generated for this run instead of -keyset. The keyset is wrapped with the master key and written to the manifest <out>.dek.json, from which the keyset-chain helper emits BigQuery decrypt expressions. -keyset is then only used to encrypt quarantined records.")
+	flag.IntVar(&c.dekBatchSize, "dek-batch-size", 0, "Generate a new data keyset every this many records, recorded as batches in the manifest. Implies -dek-per-run.")
+	flag.StringVar(&c.dekManifestDir, "dek-manifest-dir", "", "Directory of the manifests of earlier runs, whose key IDs the new data keysets must not reuse so keyset-chain can tell all their ciphertexts apart. Defaults to the directory of -out.")
+	flag.StringVar(&c.tenantField, "tenant-field", "", "Unencrypted CSV header name of the tenant or customer of each record. The fields of a record are encrypted with a keyset of its tenant from -tenant-keyset-dir instead of -keyset, so the data of a tenant can be crypto-shredded with the shred helper. Quarantined records are encrypted with -keyset. i.e. \"Customer_ID\"")
+	flag.StringVar(&c.tenantKeysetDir, "tenant-keyset-dir", "", "Directory of the per-tenant keysets, wrapped with the master key. The keyset of a new tenant is created on first use. Records of shredded tenants are rejected. Print the BigQuery expression that decrypts them with keyset-chain -tenant-keyset-dir.")
+	flag.StringVar(&c.padFields, "pad-fields", "", "Comma-separated list of encrypted CSV header names to pad before encryption, so the ciphertext length does not reveal the plaintext length. Each entry is column:bucket[:bucket...] with bucket sizes in bytes. Values are padded to the smallest bucket that fits, or to a multiple of the largest one. The decrypted view of the example strips the padding of the columns of its pad_fields variable. i.e. \"Card_Holders_Name:32:64,Card_PIN:8\"")
+	flag.StringVar(&c.generalize, "generalize", "", "Comma-separated list of column:transform to generalize unencrypted CSV header names. These transforms are not reversible. transform is year[=layout] or month[=layout] for dates, range=<width> for numbers, truncate=<n> or mask=<n> to keep the first n characters, or suppress. i.e. \"Issue_Date:year,Credit_Limit:range=10000,Zip:mask=3\"")
+	flag.StringVar(&c.dateShiftFields, "date-shift-fields", "", "Comma-separated list of unencrypted date CSV header names to shift by an offset derived from the value of -date-shift-subject, so intervals between the dates of a subject are kept without revealing them. i.e. \"Issue_Date,Expiry_Date\"")
+	flag.StringVar(&c.dateShiftSubject, "date-shift-subject", "", "CSV header name of the subject, i.e. the cardholder, whose dates are shifted by the same offset. Reversing the shift needs the subject plaintext, so it should be unencrypted or format-preserving encrypted. i.e. \"Card_Number\"")
+	flag.StringVar(&c.dateShiftKeyset, "date-shift-keyset", "", "PRF keyset filename the date offsets are derived from, i.e. created with the HMAC_SHA256_PRF key template. Wrapped with the master key. A file or keyset source like -keyset. Shifted dates can only be reversed with this keyset.")
+	flag.StringVar(&c.dateShiftMax, "date-shift-max", "12m", "Largest date offset, as a number of days (d) or months (m). Offsets are in [-max, max] and never 0. Shift by months for layouts without a day. i.e. \"365d\" or \"12m\"")
+	flag.StringVar(&c.dateShiftLayout, "date-shift-layout", "01/2006", "Layout of the dates to shift, in Go reference time format. i.e. \"01/2006\" for MM/YYYY or \"2006-01-02\"")
+	flag.StringVar(&c.dateShiftOutput, "date-shift-output-layout", "", "Layout of the shifted dates, in Go reference time format. Defaults to -date-shift-layout.")
+	flag.StringVar(&c.kAnonColumns, "k-anonymity-columns", "", "Comma-separated list of quasi-identifier CSV header names. The k-anonymity of the output over these columns is logged and written to -k-anonymity-report. i.e. \"Issue_Date,Credit_Limit\"")
+	flag.StringVar(&c.kAnonReport, "k-anonymity-report", "", "Filename to write the k-anonymity report as JSON. Not written when empty.")
+	flag.IntVar(&c.kAnonThreshold, "k-anonymity-threshold", 5, "Equivalence classes with fewer records than this are counted as below the threshold in the k-anonymity report.")
+	flag.StringVar(&c.piiAllow, "pii-allow", "", "Comma-separated list of unencrypted columns allowed to hold PII, for known false positives. An entry is a column name, or column:INFO_TYPE to allow a single info type. i.e. \"Issuing_Bank,Notes:PERSON_NAME\"")
+	flag.StringVar(&c.piiMinLikelihood, "pii-min-likelihood", "LIKELY", "The run is refused when an unencrypted column holds PII with this likelihood or higher: POSSIBLE, LIKELY or VERY_LIKELY.")
+	flag.IntVar(&c.piiSampleSize, "pii-sample-size", 10000, "Number of records checked for PII in unencrypted columns. 0 checks all records.")
+	flag.Var(&c.validateRegex, "validate-regex", "Validation rule column=regex. Records whose value does not match are rejected with PATTERN_MISMATCH. Can be repeated. i.e. -validate-regex 'CVV_CVV2=^[0-9]{3,4}$'")
+	flag.Var(&c.validateDate, "validate-date", "Validation rule column=layout, with the layout in Go reference time format. Records whose non-empty value is not a date in that layout are rejected with INVALID_DATE. Can be repeated. i.e. -validate-date 'Expiry_Date=01/2006'")
+	flag.StringVar(&c.validateLuhn, "validate-luhn", "", "Comma-separated list of card number columns. Records whose non-empty value fails the Luhn check are rejected with LUHN_FAILED. i.e. \"Card_Number\"")
+	flag.StringVar(&c.quarantine, "quarantine", "", "Filename to write rejected records to, instead of failing on the first one. Each row has the input line, the reason codes and the rejected record encrypted as a single csv line. Reason codes are PARSE_ERROR, FIELD_COUNT, INVALID_ENCODING, PATTERN_MISMATCH, LUHN_FAILED, INVALID_DATE, FPE_FAILED, GENERALIZE_FAILED, DATE_SHIFT_FAILED and TENANT_KEY_FAILED.")
+	flag.StringVar(&c.errorBudget, "error-budget", "0", "Number of records, or percentage of the records read when it ends with %, that can be quarantined. The run fails when more records are quarantined. i.e. \"100\" or \"0.5%\"")
 	flag.Parse()
 	if c.fields == "" {
 		log.Fatal("fields flag is missing. Please set fields flag that is a comma-separated list of CSV header names that need to be encrypted. i.e. -fields \"Card Type Full Name,Issuing Bank\"")
 	}
+	if c.blindIndexFields != "" && c.blindIndexKeyset == "" {
+		log.Fatal("blind-index-keyset flag is missing. A PRF keyset is required to compute blind indexes.")
+	}
+	if c.fpeFields != "" && c.fpeKeyset == "" {
+		log.Fatal("fpe-keyset flag is missing. A PRF keyset is required for format-preserving encryption.")
+	}
+	if c.dateShiftFields != "" && (c.dateShiftSubject == "" || c.dateShiftKeyset == "") {
+		log.Fatal("date-shift-subject or date-shift-keyset flag is missing. Dates are shifted per subject with a PRF keyset.")
+	}
+	if c.keyLimitAction != "warn" && c.keyLimitAction != "refuse" {
+		log.Fatalf("invalid key-limit-action %q, use warn or refuse.", c.keyLimitAction)
+	}
+	if c.dekBatchSize > 0 {
+		c.dekPerRun = true
+	}
+	if c.dekPerRun && c.tenantField != "" {
+		log.Fatal("dek-per-run and tenant-field flags can not be used together.")
+	}
+	if c.tenantField != "" && c.tenantKeysetDir == "" {
+		log.Fatal("tenant-keyset-dir flag is missing. Per-tenant keysets are stored in a directory.")
+	}
 	if c.keyset == "" {
 		log.Fatal("Keyset filename to be used to encrypt the data is missing.")
 	}
@@ -70,124 +204,949 @@ func parseFlags() genCfg {
 	return c
 }
 
-func loadMasterKeyFromKMS(ctx context.Context, c genCfg) (tink.AEAD, error) {
-	// Fetch the master key from a KMS.
-	gcpClient, err := gcpkms.NewClientWithOptions(ctx, c.masterKeyURI)
+// parseDialectRune parses a single character flag value, accepting "tab" and "\t" for a tab.
+func parseDialectRune(name, value string) (rune, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if r == utf8.RuneError || size != len(value) {
+		return 0, fmt.Errorf("%s must be a single character, got %q", name, value)
+	}
+	return r, nil
+}
+
+// newCsvReader returns a csv reader configured with the dialect flags.
+func newCsvReader(in io.Reader, c genCfg) (*csv.Reader, error) {
+	delimiter, err := parseDialectRune("delimiter", c.delimiter)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	registry.RegisterKMSClient(gcpClient)
-	masterKey, err := gcpClient.GetAEAD(c.masterKeyURI)
+	if delimiter == 0 {
+		return nil, errors.New("delimiter must not be empty")
+	}
+	comment, err := parseDialectRune("comment", c.comment)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return masterKey, err
+	r := csv.NewReader(in)
+	r.Comma = delimiter
+	r.Comment = comment
+	r.LazyQuotes = c.lazyQuotes
+	return r, nil
 }
 
-func setupKeyset(ctx context.Context, c genCfg) {
-	var err error
+// detectCRLF reports whether the first line of the input ends with "\r\n",
+// so the output can keep the line endings of the input.
+func detectCRLF(r *bufio.Reader) bool {
+	head, _ := r.Peek(r.Size())
+	i := bytes.IndexByte(head, '\n')
+	return i > 0 && head[i-1] == '\r'
+}
+
+// checkEncoding returns an error with the line and column of the first field
+// that had invalid byte sequences in the input encoding.
+func checkEncoding(r *csv.Reader, record []string, encoding string) error {
+	for index, field := range record {
+		if charset.Valid(field) {
+			continue
+		}
+		line, _ := r.FieldPos(index)
+		return fmt.Errorf("line %d, column %d: invalid %s byte sequence", line, index+1, encoding)
+	}
+	return nil
+}
+
+// columnsToEncrypt resolves the fields flag into the indexes of the columns to encrypt.
+// Without a header, fields are 1-based column numbers.
+func columnsToEncrypt(fields []string, header []string) (map[int]int, error) {
+	columns := make(map[int]int)
+
+	if header == nil {
+		for _, field := range fields {
+			number, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || number < 1 {
+				return nil, fmt.Errorf("invalid column number %q: fields must be 1-based column numbers when the input has no header", field)
+			}
+			columns[number-1] = 0
+		}
+		return columns, nil
+	}
+
+	headersToEncryptMap := make(map[string]int)
+	for _, val := range fields {
+		headersToEncryptMap[strings.ToLower(val)] = 0
+	}
+
+	for index, value := range header {
+		if _, hasKeyInMap := headersToEncryptMap[strings.ToLower(value)]; !hasKeyInMap {
+			continue
+		}
+		columns[index] = 0
+	}
+	return columns, nil
+}
+
+// Reason codes of rejected records.
+const (
+	reasonParseError      = "PARSE_ERROR"
+	reasonFieldCount      = "FIELD_COUNT"
+	reasonInvalidEncoding = "INVALID_ENCODING"
+	reasonPatternMismatch = "PATTERN_MISMATCH"
+	reasonLuhnFailed      = "LUHN_FAILED"
+	reasonInvalidDate     = "INVALID_DATE"
+	reasonFPEFailed       = "FPE_FAILED"
+	reasonGeneralize      = "GENERALIZE_FAILED"
+	reasonDateShift       = "DATE_SHIFT_FAILED"
+	reasonTenantKey       = "TENANT_KEY_FAILED"
+)
 
-	f, err := os.Open(c.keyset)
+// columnRules is a repeatable flag of column=value rules.
+type columnRules []string
+
+func (r *columnRules) String() string {
+	return strings.Join(*r, " ")
+}
+
+func (r *columnRules) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected column=value, got %q", value)
+	}
+	*r = append(*r, value)
+	return nil
+}
+
+// recordCheck validates a column of a record.
+type recordCheck struct {
+	index  int
+	name   string
+	reason string
+	valid  func(string) bool
+}
+
+// columnIndex resolves a column name, or a 1-based column number without a header.
+func columnIndex(name string, header []string) (int, error) {
+	name = strings.TrimSpace(name)
+	if header == nil {
+		number, err := strconv.Atoi(name)
+		if err != nil || number < 1 {
+			return 0, fmt.Errorf("invalid column number %q: columns must be 1-based column numbers when the input has no header", name)
+		}
+		return number - 1, nil
+	}
+	for index, value := range header {
+		if strings.EqualFold(value, name) {
+			return index, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in the csv header", name)
+}
+
+// recordChecks builds the validation rules of the flags.
+func recordChecks(c genCfg, header []string) ([]recordCheck, error) {
+	var checks []recordCheck
+	add := func(name, reason string, valid func(string) bool) error {
+		index, err := columnIndex(name, header)
+		if err != nil {
+			return err
+		}
+		if header != nil {
+			name = header[index]
+		}
+		checks = append(checks, recordCheck{index: index, name: strings.TrimSpace(name), reason: reason, valid: valid})
+		return nil
+	}
+
+	for _, rule := range c.validateRegex {
+		name, expr, _ := strings.Cut(rule, "=")
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("validate-regex %q: %w", rule, err)
+		}
+		if err := add(name, reasonPatternMismatch, re.MatchString); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range c.validateDate {
+		name, layout, _ := strings.Cut(rule, "=")
+		valid := func(value string) bool {
+			if value == "" {
+				return true
+			}
+			_, err := time.Parse(layout, value)
+			return err == nil
+		}
+		if err := add(name, reasonInvalidDate, valid); err != nil {
+			return nil, err
+		}
+	}
+	if c.validateLuhn != "" {
+		for _, name := range strings.Split(c.validateLuhn, ",") {
+			valid := func(value string) bool {
+				return value == "" || pii.Luhn(value)
+			}
+			if err := add(name, reasonLuhnFailed, valid); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return checks, nil
+}
+
+// validateRecord returns the reasons a record is rejected, as CODE:column.
+func validateRecord(checks []recordCheck, record []string) []string {
+	var reasons []string
+	for _, check := range checks {
+		if check.index >= len(record) {
+			reasons = append(reasons, reasonFieldCount+":"+check.name)
+			continue
+		}
+		if !check.valid(record[check.index]) {
+			reasons = append(reasons, check.reason+":"+check.name)
+		}
+	}
+	return reasons
+}
+
+// quarantineWriter writes rejected records, encrypted, with their reason codes.
+type quarantineWriter struct {
+	out     io.WriteCloser
+	w       *csv.Writer
+	comma   rune
+	records int
+	reasons map[string]int
+}
+
+func newQuarantineWriter(name string, comma rune) (*quarantineWriter, error) {
+	out, err := compress.Create(name, "")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer f.Close()
+	q := &quarantineWriter{out: out, w: csv.NewWriter(out), comma: comma, reasons: make(map[string]int)}
+	if err := q.w.Write([]string{"line", "reasons", "record"}); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Write quarantines a record. The record is nil when it could not be parsed.
+func (q *quarantineWriter) Write(line int, reasons []string, record []string) error {
+	var encrypted string
+	if record != nil {
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		w.Comma = q.comma
+		if err := w.Write(record); err != nil {
+			return err
+		}
+		w.Flush()
+		encrypted = encryptData(encrypter, strings.TrimSuffix(b.String(), "\n"))
+	}
+
+	q.records++
+	for _, reason := range reasons {
+		code, _, _ := strings.Cut(reason, ":")
+		q.reasons[code]++
+	}
+	return q.w.Write([]string{strconv.Itoa(line), strings.Join(reasons, ";"), encrypted})
+}
+
+// Summary returns the number of quarantined records by reason code.
+func (q *quarantineWriter) Summary() string {
+	codes := make([]string, 0, len(q.reasons))
+	for code := range q.reasons {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for i, code := range codes {
+		codes[i] = fmt.Sprintf("%s=%d", code, q.reasons[code])
+	}
+	return strings.Join(codes, ", ")
+}
+
+func (q *quarantineWriter) Close() error {
+	q.w.Flush()
+	if err := q.w.Error(); err != nil {
+		return err
+	}
+	return q.out.Close()
+}
 
-	masterKey, errKey := loadMasterKeyFromKMS(ctx, c)
-	if errKey != nil {
-		log.Fatal(errKey)
+// parseErrorBudget returns the number of records that can be quarantined out
+// of total records, for a budget in records or a percentage ending with %.
+func parseErrorBudget(budget string, total int) (int, error) {
+	if percent, ok := strings.CutSuffix(budget, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p < 0 {
+			return 0, fmt.Errorf("invalid error budget %q", budget)
+		}
+		return int(p / 100 * float64(total)), nil
+	}
+	n, err := strconv.Atoi(budget)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid error budget %q", budget)
 	}
+	return n, nil
+}
 
-	keyReader := keyset.NewJSONReader(f)
+// fpeColumn is a column encrypted with format-preserving encryption.
+type fpeColumn struct {
+	index     int
+	transform *fpe.Transform
+}
 
-	keyHandle, err := keyset.Read(keyReader, masterKey)
+// fpeColumns resolves the fpe-fields flag into the columns to encrypt with
+// format-preserving encryption, and the indexes of their tweak columns.
+func fpeColumns(spec string, header []string, encrypted map[int]int) ([]fpeColumn, map[string]int, error) {
+	transforms, err := fpeKeys.Transforms(spec)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
+	}
+
+	var columns []fpeColumn
+	transformed := make(map[int]int)
+	for _, t := range transforms {
+		index, err := columnIndex(t.Column, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := encrypted[index]; ok {
+			return nil, nil, fmt.Errorf("column %q is in both fields and fpe-fields", t.Column)
+		}
+		transformed[index] = 0
+		columns = append(columns, fpeColumn{index: index, transform: t})
+	}
+
+	tweakColumns := make(map[string]int)
+	for _, t := range transforms {
+		if t.TweakColumn == "" {
+			continue
+		}
+		index, err := columnIndex(t.TweakColumn, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, isEncrypted := encrypted[index]
+		_, isTransformed := transformed[index]
+		if isEncrypted || isTransformed {
+			return nil, nil, fmt.Errorf("tweak column %q of %q must not be encrypted, it is needed to decrypt", t.TweakColumn, t.Column)
+		}
+		tweakColumns[t.TweakColumn] = index
+	}
+	return columns, tweakColumns, nil
+}
+
+// writeKAnonymityReport logs the k-anonymity achieved and writes the report
+// to filename, if set.
+func writeKAnonymityReport(r generalize.Report, filename string) error {
+	log.Printf("k-anonymity over %s: k=%d, %d equivalence classes, %d of %d records in classes below %d",
+		strings.Join(r.QuasiIdentifiers, ","), r.K, r.EquivalenceClasses, r.RecordsBelowThreshold, r.Records, r.KThreshold)
+	if filename == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+func setupKeyset(ctx context.Context, c genCfg) {
+	var err error
+	if c.keyLedger != "" {
+		usage, err = ledger.Open(c.keyLedger, ledger.Policy{
+			MaxMessages: c.keyMaxMessages,
+			MaxAge:      time.Duration(c.keyMaxAgeDays) * 24 * time.Hour,
+			Refuse:      c.keyLimitAction == "refuse",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !c.dekPerRun || c.quarantine != "" {
+		keyHandle, err := keys.VerifyKeyset(ctx, c.keyset, c.masterKeyURI, keys.Expect{KeyType: "AES256_GCM", Fingerprint: c.keysetFingerprint})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if usage != nil {
+			k, err := keys.FetchKeyset(ctx, c.keyset)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := usage.Check(keyHandle.KeysetInfo().GetPrimaryKeyId(), k.Created); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		encrypter, err = aead.New(keyHandle)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.dekPerRun {
+		manifestDir := c.dekManifestDir
+		if manifestDir == "" {
+			manifestDir = filepath.Dir(c.out)
+		}
+		rotator, err = dek.NewRotator(ctx, c.masterKeyURI, c.dekBatchSize, c.out+dek.ManifestSuffix, manifestDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.blindIndexFields != "" {
+		indexer, err = blindindex.Load(ctx, c.blindIndexKeyset, c.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.fpeFields != "" {
+		fpeKeys, err = fpe.Load(ctx, c.fpeKeyset, c.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.tenantField != "" {
+		tenants, err = tenant.Open(ctx, c.tenantKeysetDir, c.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.dateShiftFields != "" {
+		shifter, err = dateshift.Load(ctx, c.dateShiftKeyset, c.masterKeyURI, c.dateShiftMax, c.dateShiftLayout, c.dateShiftOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
+}
 
-	encrypter, err = aead.New(keyHandle)
+func blindIndex(data string) string {
+	index, err := indexer.Compute(data)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return index
 }
 
-func encryptData(data string) string {
+func encryptData(e tink.HybridEncrypt, data string) string {
 	dataInBytes := []byte(data)
 	encryptionContext := []byte("")
 
-	encryptedData, err := encrypter.Encrypt(dataInBytes, encryptionContext)
+	encryptedData, err := e.Encrypt(dataInBytes, encryptionContext)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if auditRun != nil {
+		auditRun.Encrypted(encryptedData)
+	}
+	if usage != nil {
+		if err := usage.Record(encryptedData); err != nil {
+			// Keep the counts of the messages encrypted so far.
+			if err := usage.Save(); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+	}
 
 	return base64.StdEncoding.EncodeToString(encryptedData)
 }
 
+func newGuard(c genCfg) (*pii.Guard, error) {
+	minLikelihood, err := pii.ParseLikelihood(c.piiMinLikelihood)
+	if err != nil {
+		return nil, err
+	}
+	return pii.NewGuard(minLikelihood, c.piiSampleSize, strings.Split(c.piiAllow, ",")), nil
+}
+
+// appendAudit appends the record of the run with its status to the audit log.
+func appendAudit(c genCfg, status string) {
+	if auditRun == nil {
+		return
+	}
+	auditRun.Status = status
+	if err := audit.Append(c.auditLog, auditRun); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// refuseOutput removes the partially written output, with its data keyset
+// manifest and quarantine file, records the refusal in the audit log and
+// exits with the PII report.
+func refuseOutput(out io.Closer, c genCfg, err error) {
+	out.Close()
+	os.Remove(c.out)
+	if c.dekPerRun {
+		// The manifest would describe an output that does not exist.
+		os.Remove(c.out + dek.ManifestSuffix)
+	}
+	if c.quarantine != "" {
+		os.Remove(c.quarantine)
+	}
+	appendAudit(c, audit.StatusPIIRefused)
+	log.Fatalf("%v\nRefusing to write %s. Encrypt these columns with -fields, or allow known false positives with -pii-allow.", err, c.out)
+}
+
 func main() {
 	cfg := parseFlags()
 	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.auditLog != "" {
+		auditRun = audit.Start("csv-encrypter", "encrypt")
+		auditRun.Input.Name, auditRun.Output.Name = cfg.in, cfg.out
+		auditRun.Columns = strings.Split(cfg.fields, ",")
+		auditRun.MasterKeyURI = cfg.masterKeyURI
+	}
 	setupKeyset(ctx, cfg)
 
 	headersToEncryptList := strings.Split(cfg.fields, ",")
-	headersToEncryptMap := make(map[string]int)
 
-	for _, val := range headersToEncryptList {
-		headersToEncryptMap[strings.ToLower(val)] = 0
+	in, err := compress.Open(cfg.in)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer in.Close()
 
-	in, err := os.Open(cfg.in)
+	inDecoded, err := charset.NewReader(in, cfg.inputEncoding)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	defer in.Close()
 
-	inReader := csv.NewReader(in)
+	inBuffer := bufio.NewReaderSize(inDecoded, 64*1024)
+	useCRLF := detectCRLF(inBuffer)
 
-	headersInCsv, err := inReader.Read()
+	inReader, err := newCsvReader(inBuffer, cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	headersToEncrypt := make(map[int]int)
-
-	for index, value := range headersInCsv {
-		if _, hasKeyInMap := headersToEncryptMap[strings.ToLower(value)]; !hasKeyInMap {
-			continue
+	var headersInCsv []string
+	if !cfg.noHeader {
+		headersInCsv, err = inReader.Read()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cfg.strictEncoding {
+			if err := checkEncoding(inReader, headersInCsv, cfg.inputEncoding); err != nil {
+				log.Fatal(err)
+			}
 		}
-		headersToEncrypt[index] = 0
 	}
 
-	out, err := os.OpenFile(cfg.out, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	headersToEncrypt, err := columnsToEncrypt(headersToEncryptList, headersInCsv)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer out.Close()
 
-	outCsvWriter := csv.NewWriter(out)
-	defer outCsvWriter.Flush()
+	guard, err := newGuard(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var blindIndexColumns []int
+	if cfg.blindIndexFields != "" {
+		for _, field := range strings.Split(cfg.blindIndexFields, ",") {
+			index, err := columnIndex(field, headersInCsv)
+			if err != nil {
+				log.Fatal(err)
+			}
+			blindIndexColumns = append(blindIndexColumns, index)
+		}
+	}
+
+	var fpeCols []fpeColumn
+	tweakColumns := make(map[string]int)
+	if cfg.fpeFields != "" {
+		fpeCols, tweakColumns, err = fpeColumns(cfg.fpeFields, headersInCsv, headersToEncrypt)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	fpeRequired := make([]int, 0, len(fpeCols)+len(tweakColumns))
+	fpeIndexes := make(map[int]int)
+	for _, c := range fpeCols {
+		fpeRequired = append(fpeRequired, c.index)
+		fpeIndexes[c.index] = 0
+	}
+	for _, index := range tweakColumns {
+		fpeRequired = append(fpeRequired, index)
+	}
+
+	var tenantColumn int
+	if cfg.tenantField != "" {
+		if tenantColumn, err = columnIndex(cfg.tenantField, headersInCsv); err != nil {
+			log.Fatal(err)
+		}
+		_, encrypted := headersToEncrypt[tenantColumn]
+		_, transformed := fpeIndexes[tenantColumn]
+		if encrypted || transformed {
+			log.Fatalf("tenant field %q must not be encrypted", cfg.tenantField)
+		}
+		fpeRequired = append(fpeRequired, tenantColumn)
+	}
+
+	var shiftColumns []int
+	var shiftFields []string
+	var subjectColumn int
+	if cfg.dateShiftFields != "" {
+		if subjectColumn, err = columnIndex(cfg.dateShiftSubject, headersInCsv); err != nil {
+			log.Fatal(err)
+		}
+		fpeRequired = append(fpeRequired, subjectColumn)
+		shiftFields = strings.Split(cfg.dateShiftFields, ",")
+		for _, field := range shiftFields {
+			index, err := columnIndex(field, headersInCsv)
+			if err != nil {
+				log.Fatal(err)
+			}
+			_, encrypted := headersToEncrypt[index]
+			_, transformed := fpeIndexes[index]
+			if encrypted || transformed {
+				log.Fatalf("date shift field %q must not be encrypted", field)
+			}
+			shiftColumns = append(shiftColumns, index)
+			fpeRequired = append(fpeRequired, index)
+			fpeIndexes[index] = 0
+		}
+	}
+
+	type generalizeColumn struct {
+		index     int
+		transform generalize.Transform
+	}
+	var generalizeCols []generalizeColumn
+	if cfg.generalize != "" {
+		transforms, err := generalize.ParseTransforms(cfg.generalize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, t := range transforms {
+			index, err := columnIndex(t.Column, headersInCsv)
+			if err != nil {
+				log.Fatal(err)
+			}
+			_, encrypted := headersToEncrypt[index]
+			_, transformed := fpeIndexes[index]
+			if encrypted || transformed {
+				log.Fatalf("generalize field %q must not be encrypted or date shifted", t.Column)
+			}
+			generalizeCols = append(generalizeCols, generalizeColumn{index: index, transform: t})
+		}
+	}
+
+	var kAnon *generalize.KAnonymity
+	var kAnonColumns []int
+	if cfg.kAnonColumns != "" {
+		names := strings.Split(cfg.kAnonColumns, ",")
+		for _, name := range names {
+			index, err := columnIndex(name, headersInCsv)
+			if err != nil {
+				log.Fatal(err)
+			}
+			kAnonColumns = append(kAnonColumns, index)
+		}
+		kAnon = generalize.NewKAnonymity(names)
+	}
+
+	paddedColumns := make(map[int]padding.Field)
+	if cfg.padFields != "" {
+		padFields, err := padding.ParseFields(cfg.padFields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, f := range padFields {
+			index, err := columnIndex(f.Column, headersInCsv)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if _, ok := headersToEncrypt[index]; !ok {
+				log.Fatalf("pad field %q must also be in fields", f.Column)
+			}
+			paddedColumns[index] = f
+		}
+	}
 
-	err = outCsvWriter.Write(headersInCsv)
+	checks, err := recordChecks(cfg, headersInCsv)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if _, err := parseErrorBudget(cfg.errorBudget, 0); err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := compress.Create(cfg.out, cfg.compress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outCsvWriter := csv.NewWriter(out)
+	outCsvWriter.Comma = inReader.Comma
+	outCsvWriter.UseCRLF = useCRLF
+
+	var quarantine *quarantineWriter
+	if cfg.quarantine != "" {
+		quarantine, err = newQuarantineWriter(cfg.quarantine, inReader.Comma)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	recordsRead := 0
+
+	if !cfg.noHeader {
+		outHeader := headersInCsv
+		for _, index := range blindIndexColumns {
+			outHeader = append(outHeader, headersInCsv[index]+blindindex.Suffix)
+		}
+		err = outCsvWriter.Write(outHeader)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	for {
 		csvLine, err := inReader.Read()
 		if err == io.EOF {
 			break
 		}
+		recordsRead++
+
+		var parseErr *csv.ParseError
+		if quarantine != nil && errors.As(err, &parseErr) {
+			reason := reasonParseError
+			if errors.Is(err, csv.ErrFieldCount) {
+				reason = reasonFieldCount
+			}
+			if err := quarantine.Write(parseErr.StartLine, []string{reason}, csvLine); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
+		line, _ := inReader.FieldPos(0)
 
+		var reasons []string
+		if cfg.strictEncoding {
+			if err := checkEncoding(inReader, csvLine, cfg.inputEncoding); err != nil {
+				if quarantine == nil {
+					log.Fatal(err)
+				}
+				reasons = append(reasons, reasonInvalidEncoding)
+			}
+		}
+		for _, index := range blindIndexColumns {
+			if index >= len(csvLine) {
+				if quarantine == nil {
+					log.Fatalf("line %d: column %d to index is out of range, the record has %d fields", line, index+1, len(csvLine))
+				}
+				reasons = append(reasons, reasonFieldCount)
+				break
+			}
+		}
 		for colToEncryptIndex := range headersToEncrypt {
-			csvLine[colToEncryptIndex] = encryptData(csvLine[colToEncryptIndex])
+			if colToEncryptIndex >= len(csvLine) {
+				if quarantine == nil {
+					log.Fatalf("line %d: column %d to encrypt is out of range, the record has %d fields", line, colToEncryptIndex+1, len(csvLine))
+				}
+				reasons = append(reasons, reasonFieldCount)
+				break
+			}
+		}
+		for _, index := range fpeRequired {
+			if index >= len(csvLine) {
+				if quarantine == nil {
+					log.Fatalf("line %d: column %d to encrypt is out of range, the record has %d fields", line, index+1, len(csvLine))
+				}
+				reasons = append(reasons, reasonFieldCount)
+				break
+			}
 		}
+		reasons = append(reasons, validateRecord(checks, csvLine)...)
 
-		err = outCsvWriter.Write(csvLine)
+		fpeValues := make([]string, len(fpeCols))
+		if len(reasons) == 0 {
+			lookup := func(column string) string {
+				return csvLine[tweakColumns[column]]
+			}
+			for i, c := range fpeCols {
+				fpeValues[i], err = c.transform.Encrypt(csvLine[c.index], lookup)
+				if err != nil {
+					if quarantine == nil {
+						log.Fatalf("line %d: %v", line, err)
+					}
+					reasons = append(reasons, reasonFPEFailed+":"+c.transform.Column)
+				}
+			}
+		}
+
+		recordEncrypter := encrypter
+		if tenants != nil && len(reasons) == 0 {
+			if recordEncrypter, err = tenants.AEAD(csvLine[tenantColumn]); err != nil {
+				if quarantine == nil {
+					log.Fatalf("line %d: %v", line, err)
+				}
+				reasons = append(reasons, reasonTenantKey)
+			}
+		}
+
+		shifted := make([]string, len(shiftColumns))
+		if len(reasons) == 0 {
+			for i, index := range shiftColumns {
+				shifted[i], err = shifter.Shift(csvLine[index], csvLine[subjectColumn])
+				if err != nil {
+					if quarantine == nil {
+						log.Fatalf("line %d: %s: %v", line, shiftFields[i], err)
+					}
+					reasons = append(reasons, reasonDateShift+":"+shiftFields[i])
+				}
+			}
+		}
+
+		generalized := make([]string, len(generalizeCols))
+		if len(reasons) == 0 {
+			for i, c := range generalizeCols {
+				generalized[i], err = c.transform.Apply(csvLine[c.index])
+				if err != nil {
+					if quarantine == nil {
+						log.Fatalf("line %d: %v", line, err)
+					}
+					reasons = append(reasons, reasonGeneralize+":"+c.transform.Column)
+				}
+			}
+		}
+
+		if len(reasons) > 0 {
+			if quarantine == nil {
+				log.Fatalf("line %d: record failed validation: %s", line, strings.Join(reasons, ";"))
+			}
+			if err := quarantine.Write(line, reasons, csvLine); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+
+		if rotator != nil {
+			if recordEncrypter, err = rotator.Next(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		blindIndexes := make([]string, len(blindIndexColumns))
+		for i, index := range blindIndexColumns {
+			blindIndexes[i] = blindIndex(csvLine[index])
+		}
+
+		for colToEncryptIndex := range headersToEncrypt {
+			value := csvLine[colToEncryptIndex]
+			if f, ok := paddedColumns[colToEncryptIndex]; ok {
+				value = f.Pad(value)
+			}
+			csvLine[colToEncryptIndex] = encryptData(recordEncrypter, value)
+		}
+		for i, c := range fpeCols {
+			csvLine[c.index] = fpeValues[i]
+		}
+		for i, index := range shiftColumns {
+			csvLine[index] = shifted[i]
+		}
+		for i, c := range generalizeCols {
+			csvLine[c.index] = generalized[i]
+		}
+
+		for index, value := range csvLine {
+			if _, encrypted := headersToEncrypt[index]; encrypted {
+				continue
+			}
+			if _, encrypted := fpeIndexes[index]; encrypted {
+				continue
+			}
+			name := strconv.Itoa(index + 1)
+			if index < len(headersInCsv) {
+				name = headersInCsv[index]
+			}
+			guard.Add(name, value)
+		}
+		if err := guard.EndRecord(); err != nil {
+			refuseOutput(out, cfg, err)
+		}
+
+		if kAnon != nil {
+			values := make([]string, len(kAnonColumns))
+			for i, index := range kAnonColumns {
+				if index < len(csvLine) {
+					values[i] = csvLine[index]
+				}
+			}
+			kAnon.Add(values)
+		}
+
+		err = outCsvWriter.Write(append(csvLine, blindIndexes...))
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
+
+	if err := guard.Check(); err != nil {
+		refuseOutput(out, cfg, err)
+	}
+
+	outCsvWriter.Flush()
+	if err := outCsvWriter.Error(); err != nil {
+		log.Fatal(err)
+	}
+	// Closing the output flushes any pending compressed data.
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if usage != nil {
+		if err := usage.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if rotator != nil {
+		// Each keyset was written when created, record the final batch sizes.
+		if err := rotator.WriteManifest(); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("data keysets written to %s", cfg.out+dek.ManifestSuffix)
+	}
+
+	if kAnon != nil {
+		if err := writeKAnonymityReport(kAnon.Report(cfg.kAnonThreshold), cfg.kAnonReport); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if quarantine != nil {
+		if err := quarantine.Close(); err != nil {
+			log.Fatal(err)
+		}
+		summary := fmt.Sprintf("%d records read, %d written, %d quarantined to %s", recordsRead, recordsRead-quarantine.records, quarantine.records, cfg.quarantine)
+		if reasons := quarantine.Summary(); reasons != "" {
+			summary += ": " + reasons
+		}
+		log.Print(summary)
+		budget, _ := parseErrorBudget(cfg.errorBudget, recordsRead)
+		if quarantine.records > budget {
+			appendAudit(cfg, audit.StatusErrorBudgetExceeded)
+			log.Fatalf("%d quarantined records exceed the error budget of %s", quarantine.records, cfg.errorBudget)
+		}
+	}
+
+	appendAudit(cfg, audit.StatusOK)
 }
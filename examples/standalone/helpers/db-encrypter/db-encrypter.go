@@ -0,0 +1,621 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/microsoft/go-mssqldb"
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/tink"
+
+	"encrypter-common/audit"
+	"encrypter-common/compress"
+	"encrypter-common/keys"
+	"encrypter-common/ledger"
+	"encrypter-common/output"
+)
+
+var (
+	encrypter tink.AEAD
+	auditRun  *audit.Run
+	usage     *ledger.Ledger
+)
+
+// generator config
+type genCfg struct {
+	driver            string
+	dsn               string
+	query             string
+	queryFile         string
+	out               string
+	outFormat         string
+	fields            string
+	keyset            string
+	keysetFingerprint string
+	masterKeyURI      string
+	credentials       keys.Credentials
+	keyLedger         string
+	keyMaxMessages    uint64
+	keyMaxAgeDays     int
+	keyLimitAction    string
+	auditLog          string
+	compress          string
+	watermarkColumn   string
+	watermarkKey      string
+	watermarkFile     string
+	watermarkStart    string
+}
+
+// watermark is the state of an incremental extraction, stored in the watermark file.
+type watermark struct {
+	Column string `json:"column"`
+	Value  string `json:"value"`
+	Type   string `json:"type"`
+	// Keys are the -watermark-key values of the rows extracted with the
+	// watermark value, as JSON arrays, skipped when they are read again.
+	Keys []string `json:"keys,omitempty"`
+}
+
+func parseFlags() genCfg {
+	var c genCfg
+	flag.StringVar(&c.driver, "driver", "", "Database driver: postgres, mysql, sqlserver or sqlite3.")
+	flag.StringVar(&c.dsn, "dsn", os.Getenv("DB_ENCRYPTER_DSN"), "Data source name of the database, in the format of the driver. Defaults to the DB_ENCRYPTER_DSN environment variable, which keeps credentials out of the process list.")
+	flag.StringVar(&c.query, "query", "", "SQL query that returns the rows to encrypt.")
+	flag.StringVar(&c.queryFile, "query-file", "", "File with the SQL query, used instead of -query.")
+	flag.StringVar(&c.out, "out", "", "Filename to write encrypted data.")
+	flag.StringVar(&c.outFormat, "out-format", "csv", "The output format: csv, json or avro. All values are written as strings, NULL as an empty string.")
+	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of result column names that need to be encrypted. i.e. \"card_number,card_pin\"")
+	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data, or a keyset source: file://<path>, env://<variable> holding the keyset JSON or its base64 encoding, gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>] or vault-kv://<mount>/<path>[?version=<version>&field=<field>], i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset/versions/3\". Set KEYSET_CACHE_DIR to cache keysets fetched from Secret Manager or Vault.")
+	flag.StringVar(&c.keysetFingerprint, "keyset-fingerprint", "", "Fingerprint the keyset is pinned to, as logged by earlier runs or printed by verify-keyset. The run stops before encrypting any record if the keyset does not match, i.e. it was swapped. i.e. \"sha256:3b4c...\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.keyLedger, "key-usage-ledger", "", "Filename of a ledger counting the messages encrypted by each key ID, kept across runs. Keys are checked against -key-max-messages and -key-max-age-days. Disabled when empty.")
+	flag.Uint64Var(&c.keyMaxMessages, "key-max-messages", ledger.DefaultMaxMessages, "Number of messages a key may encrypt before it must be rotated, 0 for no limit. The default is the AES-GCM bound for random nonces.")
+	flag.IntVar(&c.keyMaxAgeDays, "key-max-age-days", 365, "Age in days past which a key must be rotated, 0 for no limit. A key is dated by the modification time of its keyset file when first recorded in the ledger.")
+	flag.StringVar(&c.keyLimitAction, "key-limit-action", "warn", "What to do when a key exceeds -key-max-messages or -key-max-age-days: warn, or refuse to encrypt.")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set.")
+	flag.StringVar(&c.watermarkColumn, "watermark-column", "", "Result column used for incremental extraction, whose values must only grow as rows are added, i.e. an insert timestamp or a sequence. Only rows with a value greater than the last extracted one are read, so without -watermark-key its values must also be unique, and the run fails on a repeated value.")
+	flag.StringVar(&c.watermarkKey, "watermark-key", "", "Comma-separated list of result columns that identify a row, i.e. the primary key, for a watermark column whose values repeat. Rows with the last extracted value are read again and those already extracted are skipped by key. i.e. \"card_id\"")
+	flag.StringVar(&c.watermarkFile, "watermark-file", "", "File that stores the last extracted watermark value. Updated after the output is written.")
+	flag.StringVar(&c.watermarkStart, "watermark-start", "", "Initial watermark value, used when the watermark file does not exist yet.")
+	flag.Parse()
+	if c.driver == "" {
+		log.Fatal("Database driver is missing.")
+	}
+	if c.dsn == "" {
+		log.Fatal("Data source name is missing. Set the dsn flag or the DB_ENCRYPTER_DSN environment variable.")
+	}
+	if (c.query == "") == (c.queryFile == "") {
+		log.Fatal("Exactly one of query or query-file must be set.")
+	}
+	if c.fields == "" {
+		log.Fatal("fields flag is missing. Please set fields flag that is a comma-separated list of column names that need to be encrypted. i.e. -fields \"card_number,card_pin\"")
+	}
+	if (c.watermarkColumn == "") != (c.watermarkFile == "") {
+		log.Fatal("watermark-column and watermark-file must be set together.")
+	}
+	if c.watermarkKey != "" && c.watermarkColumn == "" {
+		log.Fatal("watermark-key requires watermark-column.")
+	}
+	if c.keyset == "" {
+		log.Fatal("Keyset filename to be used to encrypt the data is missing.")
+	}
+	if c.keyLimitAction != "warn" && c.keyLimitAction != "refuse" {
+		log.Fatalf("invalid key-limit-action %q, use warn or refuse.", c.keyLimitAction)
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	if c.out == "" {
+		log.Fatal("Output filename is missing.")
+	}
+	return c
+}
+
+func setupKeyset(ctx context.Context, c genCfg) {
+	var err error
+	if c.keyLedger != "" {
+		usage, err = ledger.Open(c.keyLedger, ledger.Policy{
+			MaxMessages: c.keyMaxMessages,
+			MaxAge:      time.Duration(c.keyMaxAgeDays) * 24 * time.Hour,
+			Refuse:      c.keyLimitAction == "refuse",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keyHandle, err := keys.VerifyKeyset(ctx, c.keyset, c.masterKeyURI, keys.Expect{KeyType: "AES256_GCM", Fingerprint: c.keysetFingerprint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if usage != nil {
+		k, err := keys.FetchKeyset(ctx, c.keyset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := usage.Check(keyHandle.KeysetInfo().GetPrimaryKeyId(), k.Created); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	encrypter, err = aead.New(keyHandle)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func encryptData(data string) string {
+	dataInBytes := []byte(data)
+	encryptionContext := []byte("")
+
+	encryptedData, err := encrypter.Encrypt(dataInBytes, encryptionContext)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auditRun != nil {
+		auditRun.Encrypted(encryptedData)
+	}
+	if usage != nil {
+		if err := usage.Record(encryptedData); err != nil {
+			// Keep the counts of the messages encrypted so far.
+			if err := usage.Save(); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(encryptedData)
+}
+
+// placeholder returns the bind parameter syntax of the driver.
+func placeholder(driver string) (string, error) {
+	switch driver {
+	case "postgres":
+		return "$1", nil
+	case "sqlserver":
+		return "@p1", nil
+	case "mysql", "sqlite3":
+		return "?", nil
+	default:
+		return "", fmt.Errorf("invalid driver %q, must be one of: postgres, mysql, sqlserver, sqlite3", driver)
+	}
+}
+
+// isWordByte reports whether c can be part of an SQL keyword or identifier.
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// trimOrderBy removes the ORDER BY clause at the end of a query, which the
+// watermark order replaces and SQL Server rejects in a derived table. A query
+// with TOP, LIMIT, OFFSET or FETCH is kept, its ORDER BY selecting the rows.
+func trimOrderBy(query string) string {
+	type word struct {
+		text string
+		pos  int
+	}
+	// The words of the query outside parentheses, strings, quoted identifiers
+	// and comments.
+	var words []word
+	depth := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := strings.IndexByte(query[i+1:], end)
+			if j < 0 {
+				return query
+			}
+			i += j + 2
+		case strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			i += j
+		case strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i:], "*/")
+			if j < 0 {
+				return query
+			}
+			i += j + 2
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case isWordByte(c):
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			if depth == 0 {
+				words = append(words, word{strings.ToUpper(query[i:j]), i})
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+
+	for _, w := range words {
+		if w.text == "TOP" {
+			return query
+		}
+	}
+	for k := len(words) - 1; k > 0; k-- {
+		switch words[k].text {
+		case "LIMIT", "OFFSET", "FETCH":
+			return query
+		case "BY":
+			if words[k-1].text == "ORDER" {
+				return strings.TrimSpace(query[:words[k-1].pos])
+			}
+			return query
+		}
+	}
+	return query
+}
+
+// buildQuery wraps the query to read only rows after the watermark, in watermark order.
+// The query is closed on a new line, so a trailing comment does not hide the wrapping.
+// With -watermark-key, rows with the last watermark value are read again, to be
+// skipped by key.
+func buildQuery(c genCfg, last *watermark) (string, []any, error) {
+	query := c.query
+	if c.queryFile != "" {
+		b, err := os.ReadFile(c.queryFile)
+		if err != nil {
+			return "", nil, err
+		}
+		query = string(b)
+	}
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+
+	param, err := placeholder(c.driver)
+	if err != nil {
+		return "", nil, err
+	}
+	if c.watermarkColumn == "" {
+		return query, nil, nil
+	}
+
+	query = trimOrderBy(query)
+	if last == nil {
+		return fmt.Sprintf("SELECT * FROM (%s\n) AS src ORDER BY %s", query, c.watermarkColumn), nil, nil
+	}
+	value, err := last.value()
+	if err != nil {
+		return "", nil, err
+	}
+	op := ">"
+	if c.watermarkKey != "" {
+		op = ">="
+	}
+	return fmt.Sprintf("SELECT * FROM (%s\n) AS src WHERE %s %s %s ORDER BY %s", query, c.watermarkColumn, op, param, c.watermarkColumn), []any{value}, nil
+}
+
+// readWatermark returns the last extracted watermark, or nil for a full extraction.
+func readWatermark(c genCfg) (*watermark, error) {
+	if c.watermarkFile == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(c.watermarkFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if c.watermarkStart == "" {
+			return nil, nil
+		}
+		return &watermark{Column: c.watermarkColumn, Value: c.watermarkStart, Type: "string"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var w watermark
+	if err := json.Unmarshal(b, &w); err != nil {
+		return nil, fmt.Errorf("reading watermark file %s: %w", c.watermarkFile, err)
+	}
+	if !strings.EqualFold(w.Column, c.watermarkColumn) {
+		return nil, fmt.Errorf("watermark file %s is for column %q, not %q", c.watermarkFile, w.Column, c.watermarkColumn)
+	}
+	return &w, nil
+}
+
+// writeWatermark replaces the watermark file, so an interrupted run keeps the previous state.
+func writeWatermark(name string, w watermark) error {
+	b, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// value converts the stored watermark back to the type it was read with.
+func (w *watermark) value() (any, error) {
+	switch w.Type {
+	case "int":
+		return strconv.ParseInt(w.Value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(w.Value, 64)
+	case "time":
+		return time.Parse(time.RFC3339Nano, w.Value)
+	default:
+		return w.Value, nil
+	}
+}
+
+// newWatermark records a column value returned by the driver.
+func newWatermark(column string, v any) watermark {
+	w := watermark{Column: column, Value: formatValue(v), Type: "string"}
+	switch v.(type) {
+	case int64:
+		w.Type = "int"
+	case float64:
+		w.Type = "float"
+	case time.Time:
+		w.Type = "time"
+	}
+	return w
+}
+
+// formatValue converts a value returned by the driver to its string representation.
+func formatValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(t)
+	case string:
+		return t
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.auditLog != "" {
+		auditRun = audit.Start("db-encrypter", "encrypt")
+		auditRun.Input.Name, auditRun.Output.Name = cfg.query, cfg.out
+		auditRun.Columns = strings.Split(cfg.fields, ",")
+		auditRun.MasterKeyURI = cfg.masterKeyURI
+	}
+	setupKeyset(ctx, cfg)
+
+	last, err := readWatermark(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	query, args, err := buildQuery(cfg, last)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sql.Open(cfg.driver, cfg.dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fieldsToEncrypt := make(map[string]int)
+	for _, val := range strings.Split(cfg.fields, ",") {
+		fieldsToEncrypt[strings.ToLower(val)] = 0
+	}
+	columnsToEncrypt := make(map[int]int)
+	watermarkIndex := -1
+	for index, column := range columns {
+		if _, hasKeyInMap := fieldsToEncrypt[strings.ToLower(column)]; hasKeyInMap {
+			columnsToEncrypt[index] = 0
+		}
+		if strings.EqualFold(column, cfg.watermarkColumn) {
+			watermarkIndex = index
+		}
+	}
+	if cfg.watermarkColumn != "" && watermarkIndex < 0 {
+		log.Fatalf("watermark column %q is not in the query result", cfg.watermarkColumn)
+	}
+	if _, encrypted := columnsToEncrypt[watermarkIndex]; encrypted {
+		log.Fatalf("watermark column %q must not be encrypted", cfg.watermarkColumn)
+	}
+	var keyIndexes []int
+	if cfg.watermarkKey != "" {
+		for _, name := range strings.Split(cfg.watermarkKey, ",") {
+			index := slices.IndexFunc(columns, func(column string) bool {
+				return strings.EqualFold(column, strings.TrimSpace(name))
+			})
+			if index < 0 {
+				log.Fatalf("watermark key column %q is not in the query result", name)
+			}
+			keyIndexes = append(keyIndexes, index)
+		}
+	}
+	// The keys of the rows extracted with the last watermark value.
+	seen := make(map[string]bool)
+	if last != nil {
+		for _, key := range last.Keys {
+			seen[key] = true
+		}
+	}
+
+	out, err := compress.Create(cfg.out, cfg.compress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outWriter, err := output.NewWriter(out, cfg.outFormat, columns)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	values := make([]any, len(columns))
+	scanArgs := make([]any, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	count := 0
+	next := last
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			log.Fatal(err)
+		}
+
+		var w watermark
+		var key string
+		if watermarkIndex >= 0 && values[watermarkIndex] != nil {
+			w = newWatermark(columns[watermarkIndex], values[watermarkIndex])
+			sameValue := next != nil && w.Value == next.Value
+			if keyIndexes == nil {
+				if sameValue {
+					log.Fatalf("watermark column %q has the value %s more than once, rows sharing it in later runs would be skipped. Set -watermark-key.", cfg.watermarkColumn, w.Value)
+				}
+			} else {
+				keyValues := make([]string, len(keyIndexes))
+				for i, index := range keyIndexes {
+					keyValues[i] = formatValue(values[index])
+				}
+				b, err := json.Marshal(keyValues)
+				if err != nil {
+					log.Fatal(err)
+				}
+				key = string(b)
+				if !sameValue {
+					clear(seen)
+				} else if seen[key] {
+					continue
+				}
+			}
+		}
+
+		record := make([]string, len(values))
+		for i, v := range values {
+			record[i] = formatValue(v)
+		}
+		for colToEncryptIndex := range columnsToEncrypt {
+			record[colToEncryptIndex] = encryptData(record[colToEncryptIndex])
+		}
+
+		if err := outWriter.Write(record); err != nil {
+			log.Fatal(err)
+		}
+
+		if w.Column != "" {
+			if key != "" {
+				seen[key] = true
+			}
+			next = &w
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := outWriter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	// Closing the output flushes any pending compressed data.
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Encrypted %d rows into %s", count, cfg.out)
+
+	if next != nil && next != last {
+		if keyIndexes != nil {
+			next.Keys = slices.Sorted(maps.Keys(seen))
+		}
+		if err := writeWatermark(cfg.watermarkFile, *next); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Watermark %s is now %s", next.Column, next.Value)
+	}
+
+	if usage != nil {
+		if err := usage.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if auditRun != nil {
+		if err := audit.Append(cfg.auditLog, auditRun); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit appends a record of each encryption and decryption run to a
+// local audit log: who ran which tool on which host, the digests of the files
+// read and written, the columns and the keys used. The log is a JSON lines
+// file whose records are chained by hash, each holding the hash of the
+// previous one, so editing, inserting or removing a record breaks the chain
+// from there on. Verify checks the chain; keeping its head hash elsewhere,
+// i.e. by exporting the log to Cloud Logging, also detects truncation.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"time"
+
+	"encrypter-common/internal/flock"
+	"encrypter-common/ledger"
+)
+
+// File is a file read or written by a run.
+type File struct {
+	// Name is the filename, or the query the records were read with.
+	Name string `json:"name"`
+	// SHA256 is the hex digest of the file as stored, compressed or not.
+	SHA256 string `json:"sha256,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+}
+
+// Statuses of runs.
+const (
+	// StatusOK is a run that wrote its output.
+	StatusOK = "ok"
+	// StatusPIIRefused is a run that found PII in unencrypted columns and
+	// removed its output.
+	StatusPIIRefused = "pii_refused"
+	// StatusErrorBudgetExceeded is a run that quarantined more records than
+	// its error budget allows.
+	StatusErrorBudgetExceeded = "error_budget_exceeded"
+	// StatusVerifyFailed is a decrypter run whose -verify check found records
+	// that do not match the original.
+	StatusVerifyFailed = "verify_failed"
+)
+
+// Record is the audit record of a run.
+type Record struct {
+	Seq          uint64    `json:"seq"`
+	Tool         string    `json:"tool"`
+	Operation    string    `json:"operation"`
+	User         string    `json:"user"`
+	Host         string    `json:"host"`
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+	Input        File      `json:"input"`
+	Output       File      `json:"output"`
+	Columns      []string  `json:"columns,omitempty"`
+	KeyIDs       []uint32  `json:"key_ids,omitempty"`
+	MasterKeyURI string    `json:"master_key_uri,omitempty"`
+	// Values is the number of values encrypted, not counted by decrypters.
+	Values uint64 `json:"values,omitempty"`
+	// Status is how the run ended, StatusOK unless it was refused or failed
+	// verification. Runs that
+	// stop on other errors are not recorded.
+	Status string `json:"status,omitempty"`
+	// Prev is the hash of the previous record, empty for the first one.
+	Prev string `json:"prev"`
+	// Hash is the SHA-256 of the record with an empty hash.
+	Hash string `json:"hash"`
+}
+
+// Run collects the audit record of a run.
+type Run struct {
+	Record
+	keyIDs map[uint32]bool
+}
+
+// Start starts the record of a run of tool, identifying the user and host.
+func Start(tool, operation string) *Run {
+	r := &Run{Record: Record{Tool: tool, Operation: operation, Started: now()}, keyIDs: make(map[uint32]bool)}
+	if u, err := user.Current(); err == nil {
+		r.User = u.Username
+	} else {
+		r.User = os.Getenv("USER")
+	}
+	r.Host, _ = os.Hostname()
+	return r
+}
+
+// Encrypted records a value encrypted with a Tink keyset, and the key
+// identified by the prefix of its ciphertext.
+func (r *Run) Encrypted(ciphertext []byte) {
+	r.Values++
+	if id, ok := ledger.KeyID(ciphertext); ok {
+		r.AddKey(id)
+	}
+}
+
+// AddKey records a key used by the run.
+func (r *Run) AddKey(id uint32) {
+	if !r.keyIDs[id] {
+		r.keyIDs[id] = true
+		r.KeyIDs = append(r.KeyIDs, id)
+		sort.Slice(r.KeyIDs, func(i, j int) bool { return r.KeyIDs[i] < r.KeyIDs[j] })
+	}
+}
+
+// Append finishes the record of the run, with the digests of its input and
+// output files, and appends it to the audit log, creating the log if needed.
+// Runs append their record last, once all their outputs are written or they
+// were refused.
+func Append(name string, r *Run) error {
+	r.Finished = now()
+	if r.Status == "" {
+		r.Status = StatusOK
+	}
+	for _, f := range []*File{&r.Input, &r.Output} {
+		if err := digest(f); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Runs appending at the same time would chain to the same record.
+	if err := flock.Lock(f); err != nil {
+		return fmt.Errorf("locking %s: %w", name, err)
+	}
+	defer flock.Unlock(f)
+
+	last, err := lastRecord(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	rec := r.Record
+	if last != nil {
+		rec.Seq, rec.Prev = last.Seq+1, last.Hash
+	} else {
+		rec.Seq = 1
+	}
+	if rec.Hash, err = hash(rec); err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// digest sets the digest and size of a file. Files not on disk, like
+// standard input, are recorded by name.
+func digest(f *File) error {
+	if f.Name == "" || f.Name == "-" {
+		return nil
+	}
+	in, err := os.Open(f.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	h := sha256.New()
+	if f.Bytes, err = io.Copy(h, in); err != nil {
+		return err
+	}
+	f.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+func hash(rec Record) (string, error) {
+	rec.Hash = ""
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastRecord returns the last record of a log, nil if it is empty.
+func lastRecord(f *os.File) (*Record, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var last []byte
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		if line := bytes.TrimSpace(s.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := s.Err(); err != nil || last == nil {
+		return nil, err
+	}
+	var rec Record
+	if err := json.Unmarshal(last, &rec); err != nil {
+		return nil, fmt.Errorf("last record: %w", err)
+	}
+	return &rec, nil
+}
+
+// Read reads the records of an audit log.
+func Read(name string) ([]Record, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []Record
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return records, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, s.Err()
+}
+
+// Verify checks the hash chain of the records of a log, returning the error
+// of the first record that was tampered with.
+func Verify(records []Record) error {
+	prev := ""
+	for i, rec := range records {
+		h, err := hash(rec)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rec.Seq != uint64(i+1):
+			return fmt.Errorf("record %d has sequence number %d, records were removed or inserted", i+1, rec.Seq)
+		case rec.Prev != prev:
+			return fmt.Errorf("record %d does not chain to record %d, records were removed, inserted or reordered", rec.Seq, i)
+		case rec.Hash != h:
+			return fmt.Errorf("record %d does not match its hash, it was modified", rec.Seq)
+		}
+		prev = rec.Hash
+	}
+	return nil
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blindindex computes blind indexes: a keyed PRF over the normalized
+// plaintext of a column, written next to its AEAD ciphertext so equality
+// lookups work without decrypting the column.
+package blindindex
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"unicode"
+
+	"github.com/tink-crypto/tink-go/v2/prf"
+	"golang.org/x/text/unicode/norm"
+
+	"encrypter-common/keys"
+)
+
+// Suffix is appended to a column name to name its blind index column.
+const Suffix = "_bidx"
+
+// outputLength is the length in bytes of a blind index.
+const outputLength = 16
+
+// Indexer computes blind indexes with the primary key of a PRF keyset.
+type Indexer struct {
+	prfSet *prf.Set
+}
+
+// Load reads a PRF keyset, i.e. created with the HMAC_SHA256_PRF key
+// template, wrapped with the master key, and verifies it with
+// keys.VerifyKeyset before any value is derived from it.
+func Load(ctx context.Context, keysetFile, masterKeyURI string) (*Indexer, error) {
+	handle, err := keys.VerifyKeyset(ctx, keysetFile, masterKeyURI, keys.Expect{KeyType: "HMAC_SHA256_PRF"})
+	if err != nil {
+		return nil, err
+	}
+	prfSet, err := prf.NewPRFSet(handle)
+	if err != nil {
+		return nil, err
+	}
+	return &Indexer{prfSet: prfSet}, nil
+}
+
+// Normalize returns the form of a value that is indexed: NFKC normalized,
+// lower-cased, with surrounding spaces trimmed and inner spaces collapsed.
+// Spaces and dashes are removed from values made of digits only, such as
+// card numbers written as "4111 1111 1111 1111".
+func Normalize(value string) string {
+	value = strings.ToLower(norm.NFKC.String(value))
+	value = strings.Join(strings.Fields(value), " ")
+
+	digitsOnly := strings.TrimFunc(value, func(r rune) bool {
+		return unicode.IsDigit(r) || r == ' ' || r == '-'
+	}) == "" && strings.ContainsFunc(value, unicode.IsDigit)
+	if digitsOnly {
+		value = strings.NewReplacer(" ", "", "-", "").Replace(value)
+	}
+	return value
+}
+
+// Compute returns the hex encoded blind index of a value. Empty values have
+// an empty blind index.
+func (i *Indexer) Compute(value string) (string, error) {
+	value = Normalize(value)
+	if value == "" {
+		return "", nil
+	}
+	out, err := i.prfSet.ComputePrimaryPRF([]byte(value), outputLength)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(out), nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package charset transcodes input files from legacy character sets to UTF-8,
+// so encrypted values can later be decrypted with AEAD.DECRYPT_STRING in BigQuery.
+package charset
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Lookup returns the encoding for name. A nil encoding means the input is already UTF-8.
+func Lookup(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "_", "-")) {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	case "latin1", "latin-1", "iso-8859-1":
+		return charmap.ISO8859_1, nil
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252, nil
+	case "shift-jis", "sjis":
+		return japanese.ShiftJIS, nil
+	case "ebcdic", "cp037", "ibm037", "ibm-037":
+		return charmap.CodePage037, nil
+	case "cp1047", "ibm1047", "ibm-1047":
+		return charmap.CodePage1047, nil
+	case "cp1140", "ibm1140", "ibm-1140":
+		return charmap.CodePage1140, nil
+	case "utf-16":
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
+	case "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), nil
+	}
+
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil || e == nil {
+		return nil, fmt.Errorf("unsupported input encoding %q", name)
+	}
+	return e, nil
+}
+
+// NewReader returns a reader that transcodes r from the named encoding to UTF-8.
+// A UTF-8 or UTF-16 byte order mark at the start of the input takes precedence
+// over the named encoding and is removed. Invalid byte sequences are replaced
+// with the Unicode replacement character, see Valid.
+func NewReader(r io.Reader, name string) (io.Reader, error) {
+	e, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var fallback transform.Transformer = unicode.UTF8.NewDecoder()
+	if e != nil {
+		fallback = e.NewDecoder()
+	}
+	return transform.NewReader(r, unicode.BOMOverride(fallback)), nil
+}
+
+// Valid reports whether s is valid UTF-8 with no replacement characters,
+// that is, whether it was transcoded without invalid byte sequences.
+func Valid(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsRune(s, utf8.RuneError)
+}
@@ -0,0 +1,190 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compress opens input and output files of the encrypters with
+// transparent, streaming gzip or zstd compression.
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// FromFilename returns the compression algorithm implied by the file extension.
+func FromFilename(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip":
+		return Gzip
+	case ".zst", ".zstd":
+		return Zstd
+	default:
+		return None
+	}
+}
+
+// detect identifies the compression algorithm of a stream by its magic bytes,
+// falling back to the file extension.
+func detect(r *bufio.Reader, name string) string {
+	head, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return Zstd
+	default:
+		return FromFilename(name)
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Open opens the named file for reading and transparently decompresses it.
+func Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewReader(f, name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: r, closers: []io.Closer{r, f}}, nil
+}
+
+// NewReader wraps r with a decompressor detected from its first bytes or from name.
+func NewReader(r io.Reader, name string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	switch detect(br, name) {
+	case Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading gzip input %q: %w", name, err)
+		}
+		return zr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd input %q: %w", name, err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+type writeCloser struct {
+	io.Writer
+	closers []io.Closer
+}
+
+// Close flushes the compressor before closing the underlying file.
+func (w *writeCloser) Close() error {
+	var err error
+	for _, c := range w.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Create truncates or creates the named file and compresses everything
+// written to it with algorithm. An empty algorithm is inferred from the file extension.
+func Create(name, algorithm string) (io.WriteCloser, error) {
+	if algorithm == "" {
+		algorithm = FromFilename(name)
+	}
+	// An invalid algorithm must not truncate an existing file.
+	if err := validate(algorithm); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f, algorithm)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &writeCloser{Writer: w, closers: []io.Closer{w, f}}, nil
+}
+
+// validate checks that algorithm is gzip, zstd, none or empty.
+func validate(algorithm string) error {
+	switch strings.ToLower(algorithm) {
+	case Gzip, Zstd, None, "":
+		return nil
+	default:
+		return fmt.Errorf("invalid compression %q, must be one of: gzip, zstd, none", algorithm)
+	}
+}
+
+// NewWriter wraps w with a compressor for algorithm: gzip, zstd or none.
+func NewWriter(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	if err := validate(algorithm); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(algorithm) {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
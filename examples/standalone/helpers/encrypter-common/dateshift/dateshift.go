@@ -0,0 +1,153 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dateshift shifts dates by a random but consistent offset per
+// subject, such as a cardholder, so intervals between the dates of a subject
+// are kept without revealing the real dates.
+//
+// The offset is derived with a keyed PRF from the subject value, so the same
+// subject is always shifted by the same offset, and the shift can only be
+// reversed with the key.
+package dateshift
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/prf"
+
+	"encrypter-common/blindindex"
+	"encrypter-common/keys"
+)
+
+// Units of a shift.
+const (
+	Days   = "d"
+	Months = "m"
+)
+
+// Shifter shifts dates of subjects with the primary key of a PRF keyset.
+type Shifter struct {
+	// Layout is the time layout of the dates to shift, i.e. "01/2006".
+	Layout string
+	// OutputLayout is the time layout of shifted dates.
+	OutputLayout string
+
+	prfSet *prf.Set
+	max    uint64
+	unit   string
+}
+
+// Load reads a PRF keyset, i.e. created with the HMAC_SHA256_PRF key
+// template, wrapped with the master key, and verifies it with
+// keys.VerifyKeyset before any value is derived from it.
+//
+// maxShift is the largest offset, as a number of days or months, i.e. "365d"
+// or "12m". Offsets are in [-maxShift, maxShift] and never 0. Month offsets
+// need a layout without a day, and day offsets a layout with a day, so
+// shifted dates can be reversed exactly. outputLayout defaults to layout.
+func Load(ctx context.Context, keysetFile, masterKeyURI, maxShift, layout, outputLayout string) (*Shifter, error) {
+	s := &Shifter{Layout: layout, OutputLayout: outputLayout}
+	if s.OutputLayout == "" {
+		s.OutputLayout = layout
+	}
+
+	n, err := strconv.ParseUint(strings.TrimRight(maxShift, Days+Months), 10, 32)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("invalid maximum date shift %q, use a positive number of days or months, i.e. 365d or 12m", maxShift)
+	}
+	s.max = n
+	s.unit = strings.TrimLeft(maxShift, "0123456789")
+	switch {
+	case s.unit != Days && s.unit != Months:
+		return nil, fmt.Errorf("invalid maximum date shift %q, use a positive number of days or months, i.e. 365d or 12m", maxShift)
+	case s.unit == Months && (hasDay(s.Layout) || hasDay(s.OutputLayout)):
+		return nil, fmt.Errorf("date layout %q has a day, shift by days, i.e. 365d", layout)
+	case s.unit == Days && !(hasDay(s.Layout) && hasDay(s.OutputLayout)):
+		return nil, fmt.Errorf("date layout %q has no day, shift by months, i.e. 12m", layout)
+	}
+
+	handle, err := keys.VerifyKeyset(ctx, keysetFile, masterKeyURI, keys.Expect{KeyType: "HMAC_SHA256_PRF"})
+	if err != nil {
+		return nil, err
+	}
+	if s.prfSet, err = prf.NewPRFSet(handle); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// hasDay reports whether a time layout formats the day of the month or year.
+func hasDay(layout string) bool {
+	formatted := time.Date(2001, 2, 17, 0, 0, 0, 0, time.UTC).Format(layout)
+	return strings.Contains(formatted, "17") || strings.Contains(formatted, "048")
+}
+
+// KeyID returns the ID of the primary key the offsets are derived from.
+func (s *Shifter) KeyID() uint32 {
+	return s.prfSet.PrimaryID
+}
+
+// Offset returns the offset, in days or months, of the dates of a subject.
+// Subjects are compared in their normalized form, like blind indexes.
+func (s *Shifter) Offset(subject string) (int, error) {
+	subject = blindindex.Normalize(subject)
+	if subject == "" {
+		return 0, fmt.Errorf("date shift subject is empty")
+	}
+	out, err := s.prfSet.ComputePrimaryPRF([]byte("dateshift/"+subject), 8)
+	if err != nil {
+		return 0, err
+	}
+	r := binary.BigEndian.Uint64(out) % (2 * s.max)
+	if r < s.max {
+		return -int(r + 1), nil
+	}
+	return int(r - s.max + 1), nil
+}
+
+// Shift returns a date of a subject shifted by the offset of the subject.
+// Empty values are returned unchanged.
+func (s *Shifter) Shift(value, subject string) (string, error) {
+	return s.shift(value, subject, s.Layout, s.OutputLayout, 1)
+}
+
+// Unshift reverses Shift.
+func (s *Shifter) Unshift(value, subject string) (string, error) {
+	return s.shift(value, subject, s.OutputLayout, s.Layout, -1)
+}
+
+func (s *Shifter) shift(value, subject, layout, outputLayout string, sign int) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return "", fmt.Errorf("date %q does not match layout %q", value, layout)
+	}
+	offset, err := s.Offset(subject)
+	if err != nil {
+		return "", err
+	}
+	if s.unit == Months {
+		t = t.AddDate(0, sign*offset, 0)
+	} else {
+		t = t.AddDate(0, 0, sign*offset)
+	}
+	return t.Format(outputLayout), nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dek generates a fresh data encryption keyset per run, or per batch
+// of records, instead of using one long-lived keyset, so a leaked keyset
+// only exposes the records it encrypted.
+//
+// Each keyset holds a single AES256_GCM key. Tink prefixes ciphertexts with
+// the ID of the key that encrypted them, so the batch of a ciphertext is
+// found from its first bytes. The keysets are wrapped with the master key
+// and recorded in a manifest written next to the output. A keyset is written
+// to the manifest before any record is encrypted with it, so an output is
+// never left without the keysets to decrypt it.
+//
+// Key IDs are 32-bit, so they are also kept unique across the manifests of a
+// directory, for keyset-chain to decrypt the outputs of many runs in one
+// expression.
+package dek
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/tink"
+
+	"encrypter-common/keys"
+)
+
+// ManifestSuffix is appended to the output filename to name its manifest.
+const ManifestSuffix = ".dek.json"
+
+// Batch is a keyset and the records it encrypted.
+type Batch struct {
+	// Batch is the 1-based number of the batch in the output.
+	Batch int `json:"batch"`
+	// FirstRecord is the 1-based number of the first record of the batch.
+	FirstRecord int `json:"first_record"`
+	// Records is the number of records in the batch.
+	Records int `json:"records"`
+	// KeyID is the ID of the key, the 4 bytes after the first byte of its
+	// ciphertexts.
+	KeyID uint32 `json:"key_id"`
+	// Keyset is the keyset wrapped with the master key, in the format of
+	// tinkey create-keyset.
+	Keyset json.RawMessage `json:"keyset"`
+}
+
+// Manifest lists the keysets of an output.
+type Manifest struct {
+	MasterKeyURI string  `json:"master_key_uri"`
+	Batches      []Batch `json:"batches"`
+}
+
+// Rotator hands out the keyset of each record, creating a new one every
+// batch of records.
+type Rotator struct {
+	masterKey    tink.AEAD
+	batchSize    int
+	records      int
+	current      tink.AEAD
+	manifest     Manifest
+	manifestName string
+	keyIDs       map[uint32]bool
+}
+
+// NewRotator returns a Rotator creating a keyset for every batchSize records,
+// or a single keyset when batchSize is 0, and recording them in the manifest
+// file manifestName. Keysets are wrapped with the first master key of
+// masterKeyURI, a comma-separated list. Their key IDs differ from those of
+// the other manifests of manifestDir.
+func NewRotator(ctx context.Context, masterKeyURI string, batchSize int, manifestName, manifestDir string) (*Rotator, error) {
+	uri := keys.MasterKeyURIs(masterKeyURI)[0]
+	masterKey, err := keys.LoadMasterKey(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	keyIDs, err := ManifestKeyIDs(manifestDir, manifestName)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[uint32]bool, len(keyIDs))
+	for keyID := range keyIDs {
+		used[keyID] = true
+	}
+	return &Rotator{
+		masterKey:    masterKey,
+		batchSize:    batchSize,
+		manifest:     Manifest{MasterKeyURI: uri},
+		manifestName: manifestName,
+		keyIDs:       used,
+	}, nil
+}
+
+// Manifests returns the manifest files of a directory.
+func Manifests(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "*"+ManifestSuffix))
+}
+
+// ManifestKeyIDs returns the key IDs of the manifests of a directory, but the
+// manifest skip, and the manifest batch of each.
+func ManifestKeyIDs(dir, skip string) (map[uint32]string, error) {
+	names, err := Manifests(dir)
+	if err != nil {
+		return nil, err
+	}
+	keyIDs := make(map[uint32]string)
+	for _, name := range names {
+		if same, _ := sameFile(name, skip); same {
+			continue
+		}
+		m, err := ReadManifest(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, b := range m.Batches {
+			keyIDs[b.KeyID] = fmt.Sprintf("%s batch %d", name, b.Batch)
+		}
+	}
+	return keyIDs, nil
+}
+
+func sameFile(a, b string) (bool, error) {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(ia, ib), nil
+}
+
+// Next returns the primitive to encrypt the next record with.
+func (r *Rotator) Next() (tink.AEAD, error) {
+	if r.current == nil || (r.batchSize > 0 && r.records%r.batchSize == 0) {
+		if err := r.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	r.records++
+	r.manifest.Batches[len(r.manifest.Batches)-1].Records++
+	return r.current, nil
+}
+
+func (r *Rotator) rotate() error {
+	var handle *keyset.Handle
+	// Key IDs are random, make them unique in the output and the other
+	// manifests so the batch of a ciphertext is unambiguous.
+	for handle == nil || r.keyIDs[handle.KeysetInfo().GetPrimaryKeyId()] {
+		var err error
+		if handle, err = keyset.NewHandle(aead.AES256GCMKeyTemplate()); err != nil {
+			return err
+		}
+	}
+	keyID := handle.KeysetInfo().GetPrimaryKeyId()
+
+	var buf bytes.Buffer
+	if err := handle.Write(keyset.NewJSONWriter(&buf), r.masterKey); err != nil {
+		return err
+	}
+	a, err := aead.New(handle)
+	if err != nil {
+		return err
+	}
+
+	r.current = a
+	r.keyIDs[keyID] = true
+	r.manifest.Batches = append(r.manifest.Batches, Batch{
+		Batch:       len(r.manifest.Batches) + 1,
+		FirstRecord: r.records + 1,
+		KeyID:       keyID,
+		Keyset:      bytes.TrimSpace(buf.Bytes()),
+	})
+	// The manifest holds the only copy of the keyset.
+	return r.WriteManifest()
+}
+
+// WriteManifest writes the manifest of the keysets created so far, with the
+// number of records of each batch. The manifest is replaced atomically and
+// synced to disk.
+func (r *Rotator) WriteManifest() error {
+	b, err := json.MarshalIndent(r.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.manifestName), filepath.Base(r.manifestName)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), r.manifestName); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(r.manifestName))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// ReadManifest reads a manifest written by WriteManifest.
+func ReadManifest(name string) (*Manifest, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,351 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixedwidth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	sentenceEnd  = regexp.MustCompile(`\.(\s|$)`)
+	picRepeat    = regexp.MustCompile(`(.)\((\d+)\)`)
+	sequenceArea = regexp.MustCompile(`^[0-9 ]{6}`)
+)
+
+// item is an entry of the copybook, either a group or an elementary item.
+type item struct {
+	level     int
+	name      string
+	pic       string
+	usage     *Usage
+	occurs    int
+	redefines bool
+	signLead  bool
+	signSep   bool
+	children  []*item
+}
+
+// ParseCopybook reads a COBOL copybook and returns the layout of its first
+// record. Field names have hyphens replaced by underscores, so they are valid
+// BigQuery column names. FILLER and REDEFINES items are not part of the
+// output, and items with OCCURS are repeated with a _1, _2, ... suffix.
+func ParseCopybook(r io.Reader) (*Layout, error) {
+	source, err := readCopybookSource(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &item{level: 0}
+	stack := []*item{root}
+	records := 0
+	for _, sentence := range sentenceEnd.Split(source, -1) {
+		tokens := tokenize(sentence)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		it, err := parseItem(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("copybook entry %q: %w", strings.Join(tokens, " "), err)
+		}
+		if it == nil {
+			continue
+		}
+		if it.level == 1 {
+			records++
+			if records > 1 {
+				// Additional records describe alternative layouts of the same data.
+				break
+			}
+		}
+
+		for len(stack) > 1 && stack[len(stack)-1].level >= it.level {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		if it.usage == nil {
+			it.usage = parent.usage
+		}
+		parent.children = append(parent.children, it)
+		stack = append(stack, it)
+	}
+
+	layout := &Layout{}
+	if _, err := flatten(root, 0, "", layout); err != nil {
+		return nil, err
+	}
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// readCopybookSource removes comments and the sequence and identification
+// areas of fixed-format copybooks.
+func readCopybookSource(r io.Reader) (string, error) {
+	var b strings.Builder
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if len(line) >= 7 && sequenceArea.MatchString(line) {
+			if line[6] == '*' || line[6] == '/' {
+				continue
+			}
+			line = line[7:]
+			if len(line) > 65 {
+				line = line[:65]
+			}
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "*") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String(), scanner.Err()
+}
+
+// tokenize splits a copybook sentence on whitespace, keeping quoted literals together.
+func tokenize(sentence string) []string {
+	var tokens []string
+	var current strings.Builder
+	var quote rune
+	for _, c := range sentence {
+		switch {
+		case quote != 0:
+			current.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			current.WriteRune(c)
+		case c == ' ' || c == '\t' || c == '\n' || c == ',' || c == ';':
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// parseItem parses the tokens of a copybook entry. It returns nil for
+// entries that do not describe storage, such as level 66 and 88 items.
+func parseItem(tokens []string) (*item, error) {
+	level, err := strconv.Atoi(tokens[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid level number %q", tokens[0])
+	}
+	if level == 66 || level == 88 {
+		return nil, nil
+	}
+	if level == 77 {
+		level = 1
+	}
+
+	it := &item{level: level, name: "FILLER"}
+	rest := tokens[1:]
+	if len(rest) > 0 && !isClause(rest[0]) {
+		it.name = strings.ToUpper(rest[0])
+		rest = rest[1:]
+	}
+
+	usage := func(u Usage) { it.usage = &u }
+	for i := 0; i < len(rest); i++ {
+		token := strings.ToUpper(rest[i])
+		next := func() string {
+			i++
+			if i < len(rest) && strings.ToUpper(rest[i]) == "IS" {
+				i++
+			}
+			if i < len(rest) {
+				return strings.ToUpper(rest[i])
+			}
+			return ""
+		}
+
+		switch token {
+		case "PIC", "PICTURE":
+			it.pic = next()
+		case "USAGE":
+			continue
+		case "DISPLAY":
+			usage(Display)
+		case "COMP-3", "COMPUTATIONAL-3", "PACKED-DECIMAL":
+			usage(Packed)
+		case "COMP", "COMP-4", "COMP-5", "COMPUTATIONAL", "COMPUTATIONAL-4", "COMPUTATIONAL-5", "BINARY":
+			usage(Binary)
+		case "COMP-1", "COMP-2", "COMPUTATIONAL-1", "COMPUTATIONAL-2":
+			return nil, fmt.Errorf("floating point usage %s is not supported", token)
+		case "OCCURS":
+			i++
+			if i >= len(rest) {
+				return nil, fmt.Errorf("OCCURS without a count")
+			}
+			if it.occurs, err = strconv.Atoi(rest[i]); err != nil {
+				return nil, fmt.Errorf("invalid OCCURS count %q", rest[i])
+			}
+			if i+2 < len(rest) && strings.ToUpper(rest[i+1]) == "TO" {
+				return nil, fmt.Errorf("OCCURS DEPENDING ON is not supported")
+			}
+		case "REDEFINES":
+			it.redefines = true
+			i++
+		case "SIGN":
+		sign:
+			for i+1 < len(rest) {
+				switch strings.ToUpper(rest[i+1]) {
+				case "IS", "TRAILING", "CHARACTER":
+				case "LEADING":
+					it.signLead = true
+				case "SEPARATE":
+					it.signSep = true
+				default:
+					break sign
+				}
+				i++
+			}
+		case "VALUE", "VALUES":
+			// The initial value does not affect the layout.
+			i = len(rest)
+		}
+	}
+	return it, nil
+}
+
+func isClause(token string) bool {
+	switch strings.ToUpper(token) {
+	case "PIC", "PICTURE", "USAGE", "DISPLAY", "COMP", "COMP-3", "COMP-4", "COMP-5",
+		"COMPUTATIONAL", "COMPUTATIONAL-3", "COMPUTATIONAL-4", "COMPUTATIONAL-5",
+		"BINARY", "PACKED-DECIMAL", "OCCURS", "REDEFINES", "SIGN", "VALUE", "VALUES":
+		return true
+	}
+	return false
+}
+
+// flatten appends the elementary items under it to the layout, starting at offset,
+// and returns the number of bytes it occupies.
+func flatten(it *item, offset int, suffix string, layout *Layout) (int, error) {
+	times := 1
+	if it.occurs > 0 {
+		times = it.occurs
+	}
+
+	size := 0
+	for n := 1; n <= times; n++ {
+		s := suffix
+		if it.occurs > 0 {
+			s = fmt.Sprintf("%s_%d", suffix, n)
+		}
+
+		if it.pic == "" {
+			for _, child := range it.children {
+				if child.redefines {
+					continue
+				}
+				childSize, err := flatten(child, offset+size, s, layout)
+				if err != nil {
+					return 0, err
+				}
+				size += childSize
+			}
+			continue
+		}
+
+		f, err := elementaryField(it)
+		if err != nil {
+			return 0, fmt.Errorf("field %s: %w", it.name, err)
+		}
+		f.Offset = offset + size
+		size += f.Length
+		if it.name != "FILLER" {
+			f.Name = strings.ReplaceAll(it.name, "-", "_") + s
+			layout.Fields = append(layout.Fields, f)
+		}
+	}
+	return size, nil
+}
+
+// elementaryField computes the storage of an item from its PICTURE and USAGE.
+func elementaryField(it *item) (Field, error) {
+	pic := picRepeat.ReplaceAllStringFunc(it.pic, func(m string) string {
+		parts := picRepeat.FindStringSubmatch(m)
+		n, _ := strconv.Atoi(parts[2])
+		return strings.Repeat(parts[1], n)
+	})
+
+	f := Field{SignLeading: it.signLead, SignSeparate: it.signSep}
+	if it.usage != nil {
+		f.Usage = *it.usage
+	}
+
+	digits, afterPoint := 0, false
+	for _, c := range pic {
+		switch c {
+		case '9':
+			digits++
+			if afterPoint {
+				f.Scale++
+			}
+		case 'S':
+			f.Signed = true
+		case 'V':
+			afterPoint = true
+		case 'P':
+			return f, fmt.Errorf("scaling position P in PIC %s is not supported", it.pic)
+		default:
+			// Alphanumeric and edited pictures are stored as text.
+			if f.Usage != Display {
+				return f, fmt.Errorf("PIC %s cannot have a computational usage", it.pic)
+			}
+			f.Length, f.Scale, f.Signed = len(pic), 0, false
+			return f, nil
+		}
+	}
+
+	f.Numeric = true
+	switch f.Usage {
+	case Display:
+		f.Length = digits
+		if f.SignSeparate {
+			f.Length++
+		}
+	case Packed:
+		f.Length = digits/2 + 1
+	case Binary:
+		switch {
+		case digits <= 4:
+			f.Length = 2
+		case digits <= 9:
+			f.Length = 4
+		case digits <= 18:
+			f.Length = 8
+		default:
+			return f, fmt.Errorf("binary PIC %s has more than 18 digits", it.pic)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixedwidth
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding"
+)
+
+// overpunch maps the last character of a signed zoned decimal to its digit
+// and sign. The same characters are produced by EBCDIC zone nibbles C and D.
+var overpunch = map[rune]struct {
+	digit    byte
+	negative bool
+}{
+	'{': {'0', false}, 'A': {'1', false}, 'B': {'2', false}, 'C': {'3', false}, 'D': {'4', false},
+	'E': {'5', false}, 'F': {'6', false}, 'G': {'7', false}, 'H': {'8', false}, 'I': {'9', false},
+	'}': {'0', true}, 'J': {'1', true}, 'K': {'2', true}, 'L': {'3', true}, 'M': {'4', true},
+	'N': {'5', true}, 'O': {'6', true}, 'P': {'7', true}, 'Q': {'8', true}, 'R': {'9', true},
+	'p': {'0', true}, 'q': {'1', true}, 'r': {'2', true}, 's': {'3', true}, 't': {'4', true},
+	'u': {'5', true}, 'v': {'6', true}, 'w': {'7', true}, 'x': {'8', true}, 'y': {'9', true},
+}
+
+// Decoder converts records into one string per field of a layout.
+type Decoder struct {
+	layout  *Layout
+	decoder *encoding.Decoder
+}
+
+// NewDecoder returns a Decoder for text in enc. A nil enc means UTF-8 text.
+func NewDecoder(layout *Layout, enc encoding.Encoding) *Decoder {
+	d := &Decoder{layout: layout}
+	if enc != nil {
+		d.decoder = enc.NewDecoder()
+	}
+	return d
+}
+
+// Decode returns the values of the fields in record.
+func (d *Decoder) Decode(record []byte) ([]string, error) {
+	values := make([]string, len(d.layout.Fields))
+	for i, f := range d.layout.Fields {
+		if f.Offset+f.Length > len(record) {
+			return nil, fmt.Errorf("field %s: record is %d bytes long, too short for the field", f.Name, len(record))
+		}
+		raw := record[f.Offset : f.Offset+f.Length]
+
+		var err error
+		switch {
+		case f.Usage == Packed:
+			values[i], err = decodePacked(raw, f.Scale)
+		case f.Usage == Binary:
+			values[i] = decodeBinary(raw, f.Signed, f.Scale)
+		case f.Numeric:
+			var text string
+			if text, err = d.text(raw); err == nil {
+				values[i], err = decodeZoned(text, f)
+			}
+		default:
+			var text string
+			text, err = d.text(raw)
+			values[i] = strings.TrimRight(text, " \x00")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+	return values, nil
+}
+
+func (d *Decoder) text(raw []byte) (string, error) {
+	if d.decoder == nil {
+		return string(raw), nil
+	}
+	return d.decoder.String(string(raw))
+}
+
+// decodeZoned decodes a zoned decimal, with the sign overpunched on the
+// first or last digit or stored as a separate + or - character.
+func decodeZoned(text string, f Field) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		return "", nil
+	}
+
+	negative := false
+	runes := []rune(text)
+	if f.SignSeparate {
+		signAt := len(runes) - 1
+		if f.SignLeading {
+			signAt = 0
+		}
+		switch runes[signAt] {
+		case '-':
+			negative = true
+		case '+', ' ':
+		default:
+			return "", fmt.Errorf("invalid separate sign %q", runes[signAt])
+		}
+		runes = append(runes[:signAt:signAt], runes[signAt+1:]...)
+	}
+
+	digits := make([]byte, 0, len(runes))
+	for i, c := range runes {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, byte(c))
+			continue
+		}
+		signPosition := i == len(runes)-1
+		if f.SignLeading {
+			signPosition = i == 0
+		}
+		p, ok := overpunch[c]
+		if !ok || !signPosition || f.SignSeparate {
+			return "", fmt.Errorf("invalid zoned decimal %q", text)
+		}
+		digits = append(digits, p.digit)
+		negative = p.negative
+	}
+	return formatDecimal(string(digits), f.Scale, negative), nil
+}
+
+// decodePacked decodes a packed decimal, two digits per byte with the sign in the last nibble.
+func decodePacked(raw []byte, scale int) (string, error) {
+	digits := make([]byte, 0, len(raw)*2)
+	for i, b := range raw {
+		high, low := b>>4, b&0x0f
+		if high > 9 {
+			return "", fmt.Errorf("invalid packed decimal % X", raw)
+		}
+		digits = append(digits, '0'+high)
+		if i < len(raw)-1 {
+			if low > 9 {
+				return "", fmt.Errorf("invalid packed decimal % X", raw)
+			}
+			digits = append(digits, '0'+low)
+			continue
+		}
+		switch low {
+		case 0x0c, 0x0f, 0x0a, 0x0e:
+		case 0x0d, 0x0b:
+			return formatDecimal(string(digits), scale, true), nil
+		default:
+			return "", fmt.Errorf("invalid packed decimal sign in % X", raw)
+		}
+	}
+	return formatDecimal(string(digits), scale, false), nil
+}
+
+// decodeBinary decodes a big-endian binary integer of 2, 4 or 8 bytes.
+func decodeBinary(raw []byte, signed bool, scale int) string {
+	var u uint64
+	switch len(raw) {
+	case 2:
+		u = uint64(binary.BigEndian.Uint16(raw))
+		if signed {
+			u = uint64(int64(int16(u)))
+		}
+	case 4:
+		u = uint64(binary.BigEndian.Uint32(raw))
+		if signed {
+			u = uint64(int64(int32(u)))
+		}
+	default:
+		u = binary.BigEndian.Uint64(raw)
+	}
+
+	if signed && int64(u) < 0 {
+		return formatDecimal(strconv.FormatUint(uint64(-int64(u)), 10), scale, true)
+	}
+	return formatDecimal(strconv.FormatUint(u, 10), scale, false)
+}
+
+// formatDecimal places the implied decimal point and sign in a string of digits.
+func formatDecimal(digits string, scale int, negative bool) string {
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	integer := strings.TrimLeft(digits[:len(digits)-scale], "0")
+	if integer == "" {
+		integer = "0"
+	}
+	result := integer
+	if scale > 0 {
+		result += "." + digits[len(digits)-scale:]
+	}
+	if negative && strings.Trim(digits, "0") != "" {
+		result = "-" + result
+	}
+	return result
+}
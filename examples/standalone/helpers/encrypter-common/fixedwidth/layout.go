@@ -0,0 +1,190 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fixedwidth decodes fixed-width records, such as mainframe extracts
+// described by a COBOL copybook, into one string value per field.
+package fixedwidth
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Usage is the storage format of a field.
+type Usage int
+
+const (
+	// Display is text, or a zoned decimal number for numeric fields.
+	Display Usage = iota
+	// Packed is a packed decimal number (COMP-3).
+	Packed
+	// Binary is a big-endian binary integer (COMP, COMP-4, COMP-5, BINARY).
+	Binary
+)
+
+// Field is a single value in a fixed-width record.
+type Field struct {
+	Name   string
+	Offset int
+	Length int
+	Usage  Usage
+	// Numeric is set for numeric fields, which are decoded to decimal strings.
+	Numeric bool
+	Signed  bool
+	// Scale is the number of implied decimal places.
+	Scale int
+	// SignLeading and SignSeparate describe the sign of zoned decimal fields.
+	SignLeading  bool
+	SignSeparate bool
+}
+
+// Layout describes the fields of a fixed-width record.
+type Layout struct {
+	Fields       []Field
+	RecordLength int
+}
+
+// Columns returns the names of the fields, in record order.
+func (l *Layout) Columns() []string {
+	columns := make([]string, len(l.Fields))
+	for i, f := range l.Fields {
+		columns[i] = f.Name
+	}
+	return columns
+}
+
+// HasBinary reports whether the layout has packed or binary fields, whose
+// bytes may be newlines, so its records can not be newline separated.
+func (l *Layout) HasBinary() bool {
+	for _, f := range l.Fields {
+		if f.Usage == Packed || f.Usage == Binary {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Layout) validate() error {
+	if len(l.Fields) == 0 {
+		return errors.New("layout has no fields")
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range l.Fields {
+		key := strings.ToLower(f.Name)
+		if seen[key] {
+			return fmt.Errorf("duplicate field name %q", f.Name)
+		}
+		seen[key] = true
+
+		if f.Length <= 0 {
+			return fmt.Errorf("field %q must have a positive length", f.Name)
+		}
+		if f.Usage == Binary && f.Length != 2 && f.Length != 4 && f.Length != 8 {
+			return fmt.Errorf("binary field %q must be 2, 4 or 8 bytes long", f.Name)
+		}
+		if end := f.Offset + f.Length; end > l.RecordLength {
+			l.RecordLength = end
+		}
+	}
+	return nil
+}
+
+// ParseLayout reads a layout file, a CSV file with the header
+// "name,start,length,type,scale,signed" and one line per field. start is the
+// 1-based byte position of the field and type is one of string (the default),
+// zoned, packed or binary. scale and signed are optional. Lines starting with
+// "#" are comments.
+func ParseLayout(r io.Reader) (*Layout, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading layout header: %w", err)
+	}
+	index := make(map[string]int)
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"name", "start", "length"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("layout header is missing the %q column", required)
+		}
+	}
+
+	get := func(record []string, column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	layout := &Layout{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		f := Field{Name: get(record, "name")}
+		start, err := strconv.Atoi(get(record, "start"))
+		if err != nil || start < 1 {
+			return nil, fmt.Errorf("layout line %d: start must be a 1-based position", line)
+		}
+		f.Offset = start - 1
+		if f.Length, err = strconv.Atoi(get(record, "length")); err != nil {
+			return nil, fmt.Errorf("layout line %d: invalid length: %w", line, err)
+		}
+		if scale := get(record, "scale"); scale != "" {
+			if f.Scale, err = strconv.Atoi(scale); err != nil {
+				return nil, fmt.Errorf("layout line %d: invalid scale: %w", line, err)
+			}
+		}
+		if signed := get(record, "signed"); signed != "" {
+			if f.Signed, err = strconv.ParseBool(signed); err != nil {
+				return nil, fmt.Errorf("layout line %d: invalid signed: %w", line, err)
+			}
+		}
+
+		switch strings.ToLower(get(record, "type")) {
+		case "", "string":
+		case "zoned":
+			f.Numeric = true
+		case "packed":
+			f.Numeric, f.Usage = true, Packed
+		case "binary":
+			f.Numeric, f.Usage = true, Binary
+		default:
+			return nil, fmt.Errorf("layout line %d: invalid type %q, must be one of: string, zoned, packed, binary", line, get(record, "type"))
+		}
+		layout.Fields = append(layout.Fields, f)
+	}
+
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
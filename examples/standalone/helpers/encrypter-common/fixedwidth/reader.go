@@ -0,0 +1,109 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fixedwidth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Lines are records separated by newlines, as in text transfers.
+	Lines = "lines"
+	// Fixed are contiguous records of the same length (RECFM=F).
+	Fixed = "fixed"
+	// Variable are records prefixed by a record descriptor word (RECFM=V).
+	Variable = "variable"
+)
+
+// Reader splits an input stream into records.
+type Reader struct {
+	r      *bufio.Reader
+	format string
+	length int
+	pad    byte
+	count  int
+}
+
+// NewReader returns a Reader of records in format. Records of length bytes
+// that are shorter, such as text lines with trailing spaces removed, are
+// padded with pad.
+func NewReader(r io.Reader, format string, length int, pad byte) (*Reader, error) {
+	switch strings.ToLower(format) {
+	case Lines, Fixed, Variable:
+	default:
+		return nil, fmt.Errorf("invalid record format %q, must be one of: lines, fixed, variable", format)
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("record length must be positive, got %d", length)
+	}
+	return &Reader{r: bufio.NewReader(r), format: strings.ToLower(format), length: length, pad: pad}, nil
+}
+
+// Record returns the 1-based number of the last record read.
+func (r *Reader) Record() int {
+	return r.count
+}
+
+// Read returns the next record, or io.EOF at the end of the input.
+func (r *Reader) Read() ([]byte, error) {
+	var record []byte
+	var err error
+
+	switch r.format {
+	case Lines:
+		record, err = r.r.ReadBytes('\n')
+		if err == io.EOF && len(record) > 0 {
+			err = nil
+		}
+		// Only the line ending is removed, trailing bytes of the record are
+		// kept.
+		record = bytes.TrimSuffix(record, []byte("\n"))
+		record = bytes.TrimSuffix(record, []byte("\r"))
+	case Fixed:
+		record = make([]byte, r.length)
+		_, err = io.ReadFull(r.r, record)
+	case Variable:
+		rdw := make([]byte, 4)
+		if _, err = io.ReadFull(r.r, rdw); err != nil {
+			break
+		}
+		size := int(binary.BigEndian.Uint16(rdw[:2]))
+		if size < 4 {
+			return nil, fmt.Errorf("record %d: invalid record descriptor word % X", r.count+1, rdw)
+		}
+		record = make([]byte, size-4)
+		_, err = io.ReadFull(r.r, record)
+	}
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("record %d: truncated record at the end of the input", r.count+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.count++
+	if len(record) > r.length {
+		return nil, fmt.Errorf("record %d is %d bytes long, longer than the record length %d", r.count, len(record), r.length)
+	}
+	if len(record) < r.length {
+		record = append(record, bytes.Repeat([]byte{r.pad}, r.length-len(record))...)
+	}
+	return record, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fpe
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// numerals returns s as numerals of the alphabet chars.
+func numerals(t *testing.T, s, chars string) []uint16 {
+	t.Helper()
+	x := make([]uint16, len(s))
+	for i, r := range s {
+		j := strings.IndexRune(chars, r)
+		if j < 0 {
+			t.Fatalf("%q is not in the alphabet %q", r, chars)
+		}
+		x[i] = uint16(j)
+	}
+	return x
+}
+
+func fromNumerals(x []uint16, chars string) string {
+	var b strings.Builder
+	for _, n := range x {
+		b.WriteByte(chars[n])
+	}
+	return b.String()
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The FF1 samples of NIST SP 800-38G.
+var ff1Samples = []struct {
+	key, tweak string
+	chars      string
+	pt, ct     string
+}{
+	{"2B7E151628AED2A6ABF7158809CF4F3C", "", alphabets["digits"], "0123456789", "2433477484"},
+	{"2B7E151628AED2A6ABF7158809CF4F3C", "39383736353433323130", alphabets["digits"], "0123456789", "6124200773"},
+	{"2B7E151628AED2A6ABF7158809CF4F3C", "3737373770717273373737", alphabets["alnum-lower"], "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "", alphabets["digits"], "0123456789", "2830668132"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "39383736353433323130", alphabets["digits"], "0123456789", "2496655549"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "3737373770717273373737", alphabets["alnum-lower"], "0123456789abcdefghi", "xbj3kv35jrawxv32ysr"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "", alphabets["digits"], "0123456789", "6657667009"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "39383736353433323130", alphabets["digits"], "0123456789", "1001623463"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "3737373770717273373737", alphabets["alnum-lower"], "0123456789abcdefghi", "xs8a0azh2avyalyzuwd"},
+}
+
+func TestFF1Samples(t *testing.T) {
+	for i, s := range ff1Samples {
+		c, err := newFF1(mustHex(t, s.key), len(s.chars))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tweak := mustHex(t, s.tweak)
+		ct, err := c.Encrypt(numerals(t, s.pt, s.chars), tweak)
+		if err != nil {
+			t.Fatalf("sample %d: %v", i+1, err)
+		}
+		if got := fromNumerals(ct, s.chars); got != s.ct {
+			t.Errorf("sample %d: Encrypt = %s, want %s", i+1, got, s.ct)
+		}
+		pt, err := c.Decrypt(ct, tweak)
+		if err != nil {
+			t.Fatalf("sample %d: %v", i+1, err)
+		}
+		if got := fromNumerals(pt, s.chars); got != s.pt {
+			t.Errorf("sample %d: Decrypt = %s, want %s", i+1, got, s.pt)
+		}
+	}
+}
+
+// roundTrip encrypts and decrypts random values of every alphabet, from the
+// shortest allowed length up.
+func roundTrip(t *testing.T, newCipher func(key []byte, radix int) (numeralCipher, error), tweakSize int) {
+	rng := rand.New(rand.NewSource(1))
+	key := make([]byte, 32)
+	rng.Read(key)
+	for name, chars := range alphabets {
+		c, err := newCipher(key, len(chars))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for n := c.MinLen(); n < c.MinLen()+8; n++ {
+			x := make([]uint16, n)
+			for i := range x {
+				x[i] = uint16(rng.Intn(len(chars)))
+			}
+			tweak := make([]byte, tweakSize)
+			rng.Read(tweak)
+			ct, err := c.Encrypt(x, tweak)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			for _, d := range ct {
+				if int(d) >= len(chars) {
+					t.Fatalf("%s: numeral %d out of range", name, d)
+				}
+			}
+			pt, err := c.Decrypt(ct, tweak)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !slices.Equal(pt, x) {
+				t.Errorf("%s: Decrypt(Encrypt(%v)) = %v", name, x, pt)
+			}
+		}
+	}
+}
+
+func TestFF1RoundTrip(t *testing.T) {
+	roundTrip(t, func(key []byte, radix int) (numeralCipher, error) {
+		return newFF1(key, radix)
+	}, 11)
+}
+
+func TestFF1MinimumDomain(t *testing.T) {
+	c, err := newFF1(make([]byte, 16), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MinLen() != 6 {
+		t.Errorf("MinLen = %d, want 6", c.MinLen())
+	}
+	if _, err := c.Encrypt([]uint16{1, 2, 3, 4}, nil); err == nil {
+		t.Error("Encrypt of a 4-digit PIN succeeded")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fpe
+
+import (
+	"testing"
+)
+
+// The FF3 samples of NIST SP 800-38G, which use 64-bit tweaks, so they run
+// against the rounds with the tweak halves given directly.
+var ff3Samples = []struct {
+	key, tweak string
+	chars      string
+	pt, ct     string
+}{
+	{"EF4359D8D580AA4F7F036D6F04FC6A94", "D8E7920AFA330A73", alphabets["digits"], "890121234567890000", "750918814058654607"},
+	{"EF4359D8D580AA4F7F036D6F04FC6A94", "9A768A92F60E12D8", alphabets["digits"], "890121234567890000", "018989839189395384"},
+	{"EF4359D8D580AA4F7F036D6F04FC6A94", "D8E7920AFA330A73", alphabets["digits"], "89012123456789000000789000000", "48598367162252569629397416226"},
+	{"EF4359D8D580AA4F7F036D6F04FC6A94", "0000000000000000", alphabets["digits"], "89012123456789000000789000000", "34695224821734535122613701434"},
+	{"EF4359D8D580AA4F7F036D6F04FC6A94", "9A768A92F60E12D8", "0123456789abcdefghijklmnop", "0123456789abcdefghi", "g2pk40i992fn20cjakb"},
+}
+
+func TestFF3Samples(t *testing.T) {
+	for i, s := range ff3Samples {
+		c, err := newFF3(mustHex(t, s.key), len(s.chars))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tweak := mustHex(t, s.tweak)
+		tl, tr := tweak[:4], tweak[4:]
+		ct, err := c.cipher(numerals(t, s.pt, s.chars), tl, tr, true)
+		if err != nil {
+			t.Fatalf("sample %d: %v", i+1, err)
+		}
+		if got := fromNumerals(ct, s.chars); got != s.ct {
+			t.Errorf("sample %d: encrypt = %s, want %s", i+1, got, s.ct)
+		}
+		pt, err := c.cipher(ct, tl, tr, false)
+		if err != nil {
+			t.Fatalf("sample %d: %v", i+1, err)
+		}
+		if got := fromNumerals(pt, s.chars); got != s.pt {
+			t.Errorf("sample %d: decrypt = %s, want %s", i+1, got, s.pt)
+		}
+	}
+}
+
+func TestSplitTweak(t *testing.T) {
+	// SP 800-38G Rev. 1: TL = T[0..27] || 0^4 and TR = T[32..55] || T[28..31].
+	tl, tr := splitTweak(mustHex(t, "0123456789ABCD"))
+	if got, want := string(tl), string(mustHex(t, "01234560")); got != want {
+		t.Errorf("TL = %x, want %x", tl, want)
+	}
+	if got, want := string(tr), string(mustHex(t, "89ABCD70")); got != want {
+		t.Errorf("TR = %x, want %x", tr, want)
+	}
+}
+
+func TestFF3RoundTrip(t *testing.T) {
+	roundTrip(t, func(key []byte, radix int) (numeralCipher, error) {
+		return newFF3(key, radix)
+	}, FF3TweakSize)
+}
+
+func TestFF3MinimumDomain(t *testing.T) {
+	c, err := newFF3(make([]byte, 16), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MinLen() != 6 {
+		t.Errorf("MinLen = %d, want 6", c.MinLen())
+	}
+	if _, err := c.Encrypt([]uint16{1, 2, 3, 4}, make([]byte, FF3TweakSize)); err == nil {
+		t.Error("Encrypt of a 4-digit PIN succeeded")
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fpe implements format-preserving encryption of column values with
+// FF1 and FF3-1 from NIST SP 800-38G. Characters of a value that are in the
+// alphabet are encrypted to characters of the same alphabet, and the other
+// characters, such as dashes in card numbers, are kept in place.
+//
+// Values must have at least a million possible values, as SP 800-38G Rev. 1
+// requires, so PINs and other short values are not supported. They are
+// encrypted with AEAD and padded instead.
+//
+// The AES keys are derived per column and algorithm from a Tink PRF keyset,
+// so they are kept wrapped with the master key like the AEAD keysets.
+package fpe
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/tink-crypto/tink-go/v2/prf"
+
+	"encrypter-common/keys"
+)
+
+// Algorithms.
+const (
+	FF1  = "ff1"
+	FF31 = "ff3-1"
+)
+
+// Alphabets by name.
+var alphabets = map[string]string{
+	"digits":      "0123456789",
+	"hex":         "0123456789abcdef",
+	"upper-hex":   "0123456789ABCDEF",
+	"lower":       "abcdefghijklmnopqrstuvwxyz",
+	"upper":       "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum-lower": "0123456789abcdefghijklmnopqrstuvwxyz",
+	"alnum-upper": "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum":       "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+}
+
+// numeralCipher encrypts strings of numerals in [0, radix).
+type numeralCipher interface {
+	Encrypt(x []uint16, tweak []byte) ([]uint16, error)
+	Decrypt(x []uint16, tweak []byte) ([]uint16, error)
+	MinLen() int
+}
+
+// Transform encrypts the values of a column.
+type Transform struct {
+	// Column is the column the transform applies to.
+	Column string
+	// Algorithm is FF1 or FF31.
+	Algorithm string
+	// TweakColumn is the column whose plaintext value is the tweak, if any.
+	// It must not be encrypted, so values can be decrypted.
+	TweakColumn string
+
+	alphabet []rune
+	index    map[rune]uint16
+	tweak    func(lookup func(column string) string) []byte
+	cipher   numeralCipher
+}
+
+// KeySet derives the keys of transforms from a PRF keyset.
+type KeySet struct {
+	prfSet *prf.Set
+}
+
+// Load reads a PRF keyset, i.e. created with the HMAC_SHA256_PRF key
+// template, wrapped with the master key, and verifies it with
+// keys.VerifyKeyset before any value is derived from it.
+func Load(ctx context.Context, keysetFile, masterKeyURI string) (*KeySet, error) {
+	handle, err := keys.VerifyKeyset(ctx, keysetFile, masterKeyURI, keys.Expect{KeyType: "HMAC_SHA256_PRF"})
+	if err != nil {
+		return nil, err
+	}
+	prfSet, err := prf.NewPRFSet(handle)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{prfSet: prfSet}, nil
+}
+
+// KeyID returns the ID of the primary key the transform keys are derived from.
+func (k *KeySet) KeyID() uint32 {
+	return k.prfSet.PrimaryID
+}
+
+// Transforms parses a comma-separated list of transform specs,
+// column[:algorithm[:alphabet[:tweak]]], where:
+//   - algorithm is ff1 (default) or ff3-1.
+//   - alphabet is digits (default), hex, upper-hex, lower, upper, alnum-lower,
+//     alnum-upper, alnum, or chars=<characters>.
+//   - tweak is none (default), column to use the column name,
+//     const=<text> or value=<column> to use the value of another column.
+//
+// i.e. "Card_Number:ff1:digits:value=Card_Type_Code"
+func (k *KeySet) Transforms(spec string) ([]*Transform, error) {
+	var transforms []*Transform
+	for _, s := range strings.Split(spec, ",") {
+		t, err := k.transform(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("fpe field %q: %w", s, err)
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+func (k *KeySet) transform(spec string) (*Transform, error) {
+	parts := strings.SplitN(spec, ":", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	column, algorithm, alphabet, tweak := parts[0], strings.ToLower(parts[1]), parts[2], parts[3]
+	if column == "" {
+		return nil, fmt.Errorf("column name is missing")
+	}
+	if algorithm == "" {
+		algorithm = FF1
+	}
+	if alphabet == "" {
+		alphabet = "digits"
+	}
+
+	t := &Transform{Column: column, Algorithm: algorithm, index: make(map[rune]uint16)}
+
+	chars, ok := strings.CutPrefix(alphabet, "chars=")
+	if !ok {
+		if chars, ok = alphabets[strings.ToLower(alphabet)]; !ok {
+			return nil, fmt.Errorf("unknown alphabet %q", alphabet)
+		}
+	}
+	for _, r := range chars {
+		if _, dup := t.index[r]; dup {
+			return nil, fmt.Errorf("alphabet has %q twice", r)
+		}
+		t.index[r] = uint16(len(t.alphabet))
+		t.alphabet = append(t.alphabet, r)
+	}
+
+	// Each column and algorithm has its own AES-256 key.
+	key, err := k.prfSet.ComputePrimaryPRF([]byte("fpe/"+algorithm+"/"+strings.ToLower(column)), 32)
+	if err != nil {
+		return nil, err
+	}
+	switch algorithm {
+	case FF1:
+		t.cipher, err = newFF1(key, len(t.alphabet))
+	case FF31:
+		t.cipher, err = newFF3(key, len(t.alphabet))
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q, use ff1 or ff3-1", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var source func(lookup func(string) string) string
+	switch name, value, _ := strings.Cut(tweak, "="); strings.ToLower(name) {
+	case "", "none":
+		source = func(func(string) string) string { return "" }
+	case "column":
+		source = func(func(string) string) string { return column }
+	case "const":
+		source = func(func(string) string) string { return value }
+	case "value":
+		if value == "" {
+			return nil, fmt.Errorf("tweak column name is missing")
+		}
+		t.TweakColumn = value
+		source = func(lookup func(string) string) string { return lookup(value) }
+	default:
+		return nil, fmt.Errorf("unknown tweak source %q, use none, column, const=<text> or value=<column>", tweak)
+	}
+	t.tweak = func(lookup func(string) string) []byte {
+		tweak := source(lookup)
+		if algorithm == FF31 {
+			// FF3-1 tweaks are exactly 56 bits.
+			sum := sha256.Sum256([]byte(tweak))
+			return sum[:FF3TweakSize]
+		}
+		return []byte(tweak)
+	}
+	return t, nil
+}
+
+// Encrypt encrypts the characters of value in the alphabet. lookup returns
+// the plaintext value of another column of the record, for tweaks from values.
+func (t *Transform) Encrypt(value string, lookup func(column string) string) (string, error) {
+	return t.apply(value, lookup, t.cipher.Encrypt)
+}
+
+// Decrypt reverses Encrypt.
+func (t *Transform) Decrypt(value string, lookup func(column string) string) (string, error) {
+	return t.apply(value, lookup, t.cipher.Decrypt)
+}
+
+func (t *Transform) apply(value string, lookup func(string) string, f func([]uint16, []byte) ([]uint16, error)) (string, error) {
+	runes := []rune(value)
+	var positions []int
+	var x []uint16
+	for i, r := range runes {
+		if d, ok := t.index[r]; ok {
+			positions = append(positions, i)
+			x = append(x, d)
+		}
+	}
+	if len(x) == 0 {
+		return value, nil
+	}
+	if len(x) < t.cipher.MinLen() {
+		return "", fmt.Errorf("%s: %s needs at least %d characters of the alphabet, got %d", t.Column, t.Algorithm, t.cipher.MinLen(), len(x))
+	}
+
+	y, err := f(x, t.tweak(lookup))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", t.Column, err)
+	}
+	for i, p := range positions {
+		runes[p] = t.alphabet[y[i]]
+	}
+	return string(runes), nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package generalize implements non-reversible generalization transforms,
+// such as reducing dates to the year or numbers to ranges, and measures the
+// k-anonymity of the result.
+package generalize
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are tried in order when a date transform has no layout.
+var dateLayouts = []string{
+	"2006-01-02",
+	"01/2006",
+	"01/02/2006",
+	"2006/01/02",
+	"2006-01",
+	"01/06",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+}
+
+// Transform generalizes the values of a column.
+type Transform struct {
+	// Column is the column the transform applies to.
+	Column string
+	apply  func(string) (string, error)
+}
+
+// Apply generalizes a value. Empty values stay empty.
+func (t Transform) Apply(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return value, nil
+	}
+	out, err := t.apply(strings.TrimSpace(value))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", t.Column, err)
+	}
+	return out, nil
+}
+
+// ParseTransforms parses a comma-separated list of column:transform, where
+// transform is one of:
+//   - year[=layout]: the year of a date, i.e. "2008".
+//   - month[=layout]: the year and month of a date, i.e. "2008-09".
+//   - range=<width>: the range of width the number falls in, i.e. "70000-79999".
+//   - truncate=<n>: the first n characters.
+//   - mask=<n>: the first n characters, with the rest replaced by '*'.
+//   - suppress: an empty value.
+//
+// Date layouts are in Go reference time format. Without a layout, common
+// layouts such as 2006-01-02 and 01/2006 are tried.
+// i.e. "Issue_Date:year,Credit_Limit:range=10000,Zip:mask=3"
+func ParseTransforms(spec string) ([]Transform, error) {
+	var transforms []Transform
+	for _, s := range strings.Split(spec, ",") {
+		column, transform, ok := strings.Cut(strings.TrimSpace(s), ":")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("generalize field %q: expected column:transform", s)
+		}
+		apply, err := parseTransform(transform)
+		if err != nil {
+			return nil, fmt.Errorf("generalize field %q: %w", s, err)
+		}
+		transforms = append(transforms, Transform{Column: column, apply: apply})
+	}
+	return transforms, nil
+}
+
+func parseTransform(transform string) (func(string) (string, error), error) {
+	name, arg, hasArg := strings.Cut(transform, "=")
+	switch strings.ToLower(name) {
+	case "year":
+		return dateTransform(arg, "2006"), nil
+	case "month":
+		return dateTransform(arg, "2006-01"), nil
+	case "range":
+		width, err := strconv.ParseFloat(arg, 64)
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("invalid range width %q", arg)
+		}
+		return func(value string) (string, error) {
+			return numericRange(value, width)
+		}, nil
+	case "truncate", "mask":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid number of characters %q", arg)
+		}
+		mask := strings.EqualFold(name, "mask")
+		return func(value string) (string, error) {
+			runes := []rune(value)
+			if len(runes) <= n {
+				return value, nil
+			}
+			if mask {
+				return string(runes[:n]) + strings.Repeat("*", len(runes)-n), nil
+			}
+			return string(runes[:n]), nil
+		}, nil
+	case "suppress":
+		if hasArg {
+			return nil, fmt.Errorf("suppress takes no argument")
+		}
+		return func(string) (string, error) { return "", nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown transform %q, use year, month, range, truncate, mask or suppress", transform)
+	}
+}
+
+func dateTransform(layout, outLayout string) func(string) (string, error) {
+	layouts := dateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	return func(value string) (string, error) {
+		for _, l := range layouts {
+			if t, err := time.Parse(l, value); err == nil {
+				return t.Format(outLayout), nil
+			}
+		}
+		return "", fmt.Errorf("%q is not a date", value)
+	}
+}
+
+// numericRange returns the range of width that value falls in. Integer
+// ranges are inclusive, i.e. "70000-79999", others are half-open, i.e. "[0.5, 1)".
+func numericRange(value string, width float64) (string, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", fmt.Errorf("%q is not a number", value)
+	}
+	lower := math.Floor(v/width) * width
+	if width == math.Trunc(width) {
+		return fmt.Sprintf("%.0f-%.0f", lower, lower+width-1), nil
+	}
+	return fmt.Sprintf("[%s, %s)", strconv.FormatFloat(lower, 'f', -1, 64), strconv.FormatFloat(lower+width, 'f', -1, 64)), nil
+}
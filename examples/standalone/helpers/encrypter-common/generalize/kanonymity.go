@@ -0,0 +1,79 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generalize
+
+import (
+	"sort"
+	"strings"
+)
+
+// KAnonymity counts the records of each equivalence class, the records that
+// share the same values of the quasi-identifier columns.
+type KAnonymity struct {
+	columns []string
+	classes map[string]int
+	records int
+}
+
+// Report is the k-anonymity achieved over the quasi-identifier columns.
+type Report struct {
+	QuasiIdentifiers      []string `json:"quasi_identifiers"`
+	Records               int      `json:"records"`
+	EquivalenceClasses    int      `json:"equivalence_classes"`
+	K                     int      `json:"k"`
+	KThreshold            int      `json:"k_threshold"`
+	ClassesBelowThreshold int      `json:"classes_below_threshold"`
+	RecordsBelowThreshold int      `json:"records_below_threshold"`
+	// ClassSizes is the number of equivalence classes by size.
+	ClassSizes map[int]int `json:"class_sizes"`
+}
+
+// NewKAnonymity returns an empty KAnonymity over the quasi-identifier columns.
+func NewKAnonymity(columns []string) *KAnonymity {
+	return &KAnonymity{columns: columns, classes: make(map[string]int)}
+}
+
+// Add counts a record, given its values of the quasi-identifier columns.
+func (k *KAnonymity) Add(values []string) {
+	k.classes[strings.Join(values, "\x00")]++
+	k.records++
+}
+
+// Report returns the k-anonymity of the records counted so far. Classes with
+// fewer than threshold records are reported as below the threshold.
+func (k *KAnonymity) Report(threshold int) Report {
+	r := Report{
+		QuasiIdentifiers:   k.columns,
+		Records:            k.records,
+		EquivalenceClasses: len(k.classes),
+		KThreshold:         threshold,
+		ClassSizes:         make(map[int]int),
+	}
+
+	sizes := make([]int, 0, len(k.classes))
+	for _, size := range k.classes {
+		sizes = append(sizes, size)
+		r.ClassSizes[size]++
+		if size < threshold {
+			r.ClassesBelowThreshold++
+			r.RecordsBelowThreshold += size
+		}
+	}
+	if len(sizes) > 0 {
+		sort.Ints(sizes)
+		r.K = sizes[0]
+	}
+	return r
+}
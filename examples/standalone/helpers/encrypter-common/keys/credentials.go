@@ -0,0 +1,177 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Credentials selects the identity used to access Cloud KMS and Secret
+// Manager. Application Default Credentials are used when it is empty.
+type Credentials struct {
+	// File is a service account key file.
+	File string
+	// WorkloadIdentityConfig is a workload identity federation configuration
+	// file, created by gcloud iam workload-identity-pools create-cred-config.
+	WorkloadIdentityConfig string
+	// ImpersonateServiceAccount is the service account to act as, using the
+	// credentials above. A comma-separated list is a delegation chain ending
+	// with the service account to act as, like gcloud
+	// --impersonate-service-account.
+	ImpersonateServiceAccount string
+}
+
+// RegisterCredentialFlags defines the -credentials-file,
+// -workload-identity-config and -impersonate-service-account flags in fs,
+// which set c. All the tools accept them, so the identity is chosen the same
+// way everywhere.
+func RegisterCredentialFlags(fs *flag.FlagSet, c *Credentials) {
+	fs.StringVar(&c.File, "credentials-file", "", "Service account key file to access Cloud KMS and Secret Manager with, instead of Application Default Credentials. i.e. \"/etc/encrypter/sa-key.json\"")
+	fs.StringVar(&c.WorkloadIdentityConfig, "workload-identity-config", "", "Workload identity federation configuration file to access Cloud KMS and Secret Manager with, created by gcloud iam workload-identity-pools create-cred-config. i.e. \"/etc/encrypter/wif-config.json\"")
+	fs.StringVar(&c.ImpersonateServiceAccount, "impersonate-service-account", "", "Service account to impersonate with the credentials, which need roles/iam.serviceAccountTokenCreator on it. A comma-separated list is a delegation chain ending with the service account to impersonate. i.e. \"encrypter@PROJECT_ID.iam.gserviceaccount.com\"")
+}
+
+// tokenSource is the token source of the credentials set with
+// SetCredentials, nil for Application Default Credentials.
+var tokenSource oauth2.TokenSource
+
+// SetCredentials sets the identity used by LoadMasterKey and FetchKeyset, and
+// logs it. It is called once, before loading keys.
+func SetCredentials(ctx context.Context, c Credentials) error {
+	if c.File != "" && c.WorkloadIdentityConfig != "" {
+		return errors.New("set either a credentials file or a workload identity federation configuration, not both")
+	}
+
+	var creds *google.Credentials
+	var identity string
+	var err error
+	switch {
+	case c.File != "":
+		creds, identity, err = readCredentials(ctx, c.File, "service_account", "authorized_user")
+	case c.WorkloadIdentityConfig != "":
+		creds, identity, err = readCredentials(ctx, c.WorkloadIdentityConfig, "external_account")
+	default:
+		if c.ImpersonateServiceAccount == "" {
+			log.Print("Using Application Default Credentials.")
+			return nil
+		}
+		creds, err = google.FindDefaultCredentials(ctx, cloudPlatformScope)
+		identity = "Application Default Credentials"
+	}
+	if err != nil {
+		return err
+	}
+	tokenSource = creds.TokenSource
+
+	if c.ImpersonateServiceAccount != "" {
+		chain := strings.Split(c.ImpersonateServiceAccount, ",")
+		target := strings.TrimSpace(chain[len(chain)-1])
+		var delegates []string
+		for _, d := range chain[:len(chain)-1] {
+			delegates = append(delegates, strings.TrimSpace(d))
+		}
+		tokenSource, err = impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: target,
+			Scopes:          []string{cloudPlatformScope},
+			Delegates:       delegates,
+		}, option.WithTokenSource(tokenSource))
+		if err != nil {
+			return fmt.Errorf("impersonating %s: %w", target, err)
+		}
+		identity = fmt.Sprintf("service account %s impersonated with %s", target, identity)
+	}
+	log.Printf("Using %s.", identity)
+	return nil
+}
+
+// readCredentials reads a credentials file of one of the types given and
+// describes the identity it holds.
+func readCredentials(ctx context.Context, name string, types ...string) (*google.Credentials, string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, "", err
+	}
+	var f struct {
+		Type                           string `json:"type"`
+		ClientEmail                    string `json:"client_email"`
+		Audience                       string `json:"audience"`
+		ServiceAccountImpersonationURL string `json:"service_account_impersonation_url"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	if !contains(types, f.Type) {
+		return nil, "", fmt.Errorf("%s holds %q credentials, want %s", name, f.Type, strings.Join(types, " or "))
+	}
+	creds, err := google.CredentialsFromJSON(ctx, b, cloudPlatformScope)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+
+	var identity string
+	switch {
+	case f.ClientEmail != "":
+		identity = "service account " + f.ClientEmail
+	case f.ServiceAccountImpersonationURL != "":
+		// .../serviceAccounts/<email>:generateAccessToken
+		email := f.ServiceAccountImpersonationURL[strings.LastIndex(f.ServiceAccountImpersonationURL, "/")+1:]
+		identity = fmt.Sprintf("service account %s through workload identity pool %s", strings.TrimSuffix(email, ":generateAccessToken"), f.Audience)
+	case f.Audience != "":
+		identity = "workload identity pool " + f.Audience
+	default:
+		identity = "user credentials"
+	}
+	return creds, fmt.Sprintf("%s from %s", identity, name), nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// clientOptions returns the options of the Cloud KMS client.
+func clientOptions() []option.ClientOption {
+	if tokenSource == nil {
+		return nil
+	}
+	return []option.ClientOption{option.WithTokenSource(tokenSource)}
+}
+
+// httpClient returns a client authorized to call Google Cloud APIs.
+func httpClient(ctx context.Context) (*http.Client, error) {
+	if tokenSource == nil {
+		return google.DefaultClient(ctx, cloudPlatformScope)
+	}
+	return oauth2.NewClient(ctx, tokenSource), nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keys loads the data encryption keyset used by the encrypters,
+// unwrapping it with the master key (KEK) held in Cloud KMS, or with one of
+// several master keys for a multi-wrapped keyset.
+package keys
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/tink-crypto/tink-go-gcpkms/v2/integration/gcpkms"
+	"github.com/tink-crypto/tink-go/v2/core/registry"
+	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+// LoadMasterKey fetches the master key from a KMS, or opens it in a PKCS#11
+// token for pkcs11:// URIs.
+func LoadMasterKey(ctx context.Context, masterKeyURI string) (tink.AEAD, error) {
+	if isPKCS11(masterKeyURI) {
+		return loadPKCS11(masterKeyURI)
+	}
+
+	gcpClient, err := gcpkms.NewClientWithOptions(ctx, masterKeyURI, clientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	registry.RegisterKMSClient(gcpClient)
+
+	return gcpClient.GetAEAD(masterKeyURI)
+}
+
+// ReadKeyset reads a JSON keyset from a keyset source, a file unless it has
+// one of the schemes of FetchKeyset, and decrypts it with the master key.
+// A multi-wrapped keyset file is decrypted with the first reachable of its
+// master keys, trying the ones in masterKeyURI, a comma-separated list, first.
+func ReadKeyset(ctx context.Context, keysetFile, masterKeyURI string) (*keyset.Handle, error) {
+	k, err := FetchKeyset(ctx, keysetFile)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapKeyset(ctx, k.Data, masterKeyURI)
+}
+
+// unwrapKeyset decrypts a JSON keyset, multi-wrapped or not, with the master key.
+func unwrapKeyset(ctx context.Context, b []byte, masterKeyURI string) (*keyset.Handle, error) {
+	if m, ok := parseMultiWrapped(b); ok {
+		return m.Unwrap(ctx, masterKeyURI)
+	}
+	if uris := MasterKeyURIs(masterKeyURI); len(uris) > 1 {
+		m := &MultiWrapped{}
+		for _, uri := range uris {
+			m.Wrappings = append(m.Wrappings, Wrapping{MasterKeyURI: uri, Keyset: b})
+		}
+		return m.Unwrap(ctx, "")
+	}
+
+	masterKey, err := LoadMasterKey(ctx, masterKeyURI)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyset.Read(keyset.NewJSONReader(bytes.NewReader(b)), masterKey)
+}
@@ -0,0 +1,194 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tink-crypto/tink-go/v2/keyset"
+)
+
+// Wrapping is a keyset encrypted with one master key, in the JSON format
+// written by tinkey create-keyset.
+type Wrapping struct {
+	MasterKeyURI string          `json:"masterKeyUri"`
+	Keyset       json.RawMessage `json:"keyset"`
+}
+
+// MultiWrapped is a keyset file holding the same keyset wrapped with several
+// master keys, i.e. in different regions, so the keyset can be unwrapped with
+// whichever master key is reachable.
+type MultiWrapped struct {
+	Wrappings []Wrapping `json:"wrappings"`
+}
+
+// MasterKeyURIs splits a comma-separated list of master key URIs, as given to
+// the -master-key-uri flag of the tools.
+func MasterKeyURIs(masterKeyURI string) []string {
+	var uris []string
+	for _, uri := range strings.Split(masterKeyURI, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// parseMultiWrapped parses the content of a keyset file, and reports whether
+// it is a multi-wrapped keyset.
+func parseMultiWrapped(b []byte) (*MultiWrapped, bool) {
+	var m MultiWrapped
+	if err := json.Unmarshal(b, &m); err != nil || len(m.Wrappings) == 0 {
+		return nil, false
+	}
+	return &m, true
+}
+
+// ReadMultiWrapped reads a keyset file. A keyset file wrapped with a single
+// master key is returned as a multi-wrapped keyset with that master key.
+func ReadMultiWrapped(keysetFile, masterKeyURI string) (*MultiWrapped, error) {
+	b, err := os.ReadFile(keysetFile)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := parseMultiWrapped(b); ok {
+		return m, nil
+	}
+	uris := MasterKeyURIs(masterKeyURI)
+	if len(uris) != 1 {
+		return nil, fmt.Errorf("%s is wrapped with a single master key, set it with -master-key-uri", keysetFile)
+	}
+	return &MultiWrapped{Wrappings: []Wrapping{{MasterKeyURI: uris[0], Keyset: bytes.TrimSpace(b)}}}, nil
+}
+
+// Unwrap decrypts the keyset with the first master key that works. The master
+// keys in preferred, a comma-separated list, are tried first, then the other
+// master keys of the file in order.
+func (m *MultiWrapped) Unwrap(ctx context.Context, preferred string) (*keyset.Handle, error) {
+	order := make([]Wrapping, 0, len(m.Wrappings))
+	for _, uri := range MasterKeyURIs(preferred) {
+		if w, ok := m.find(uri); ok {
+			order = append(order, w)
+		}
+	}
+	for _, w := range m.Wrappings {
+		if !containsURI(order, w.MasterKeyURI) {
+			order = append(order, w)
+		}
+	}
+
+	var errs []error
+	for _, w := range order {
+		handle, err := w.unwrap(ctx)
+		if err == nil {
+			if len(errs) > 0 {
+				log.Printf("keyset unwrapped with %s", w.MasterKeyURI)
+			}
+			return handle, nil
+		}
+		log.Printf("master key %s is unavailable: %v", w.MasterKeyURI, err)
+		errs = append(errs, fmt.Errorf("%s: %w", w.MasterKeyURI, err))
+	}
+	return nil, fmt.Errorf("no master key could unwrap the keyset: %w", errors.Join(errs...))
+}
+
+func (w Wrapping) unwrap(ctx context.Context) (*keyset.Handle, error) {
+	masterKey, err := LoadMasterKey(ctx, w.MasterKeyURI)
+	if err != nil {
+		return nil, err
+	}
+	return keyset.Read(keyset.NewJSONReader(bytes.NewReader(w.Keyset)), masterKey)
+}
+
+func (m *MultiWrapped) find(uri string) (Wrapping, bool) {
+	for _, w := range m.Wrappings {
+		if w.MasterKeyURI == uri {
+			return w, true
+		}
+	}
+	return Wrapping{}, false
+}
+
+func containsURI(wrappings []Wrapping, uri string) bool {
+	for _, w := range wrappings {
+		if w.MasterKeyURI == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// Add wraps the keyset with another master key.
+func (m *MultiWrapped) Add(ctx context.Context, handle *keyset.Handle, masterKeyURI string) error {
+	if _, ok := m.find(masterKeyURI); ok {
+		return fmt.Errorf("keyset is already wrapped with %s", masterKeyURI)
+	}
+	masterKey, err := LoadMasterKey(ctx, masterKeyURI)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := handle.Write(keyset.NewJSONWriter(&buf), masterKey); err != nil {
+		return fmt.Errorf("%s: %w", masterKeyURI, err)
+	}
+	m.Wrappings = append(m.Wrappings, Wrapping{MasterKeyURI: masterKeyURI, Keyset: bytes.TrimSpace(buf.Bytes())})
+	return nil
+}
+
+// Remove removes the wrapping with a master key. The last wrapping can not be
+// removed.
+func (m *MultiWrapped) Remove(masterKeyURI string) error {
+	for i, w := range m.Wrappings {
+		if w.MasterKeyURI != masterKeyURI {
+			continue
+		}
+		if len(m.Wrappings) == 1 {
+			return fmt.Errorf("%s is the only master key of the keyset", masterKeyURI)
+		}
+		m.Wrappings = append(m.Wrappings[:i], m.Wrappings[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("keyset is not wrapped with %s", masterKeyURI)
+}
+
+// WriteFile writes the multi-wrapped keyset to a file. The file is replaced
+// atomically, so a failed write does not lose the existing wrappings.
+func (m *MultiWrapped) WriteFile(name string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,58 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// PKCS11Prefix is the prefix of the URIs of master keys held in a PKCS#11
+// token, i.e. an on-prem HSM:
+//
+//	pkcs11://<module path>?token=<token label>&object=<key label>
+//
+// i.e. pkcs11:///usr/lib/softhsm/libsofthsm2.so?token=onprem&object=kek
+//
+// The key is an AES key used with AES-GCM, so keysets wrapped with it keep
+// the JSON format of tinkey. The user PIN of the token is read from the
+// PKCS11_PIN environment variable, so it is not logged with the URI.
+// Support is built in with the pkcs11 build tag, as it needs cgo.
+const PKCS11Prefix = "pkcs11://"
+
+// pkcs11Key is a master key in a PKCS#11 token.
+type pkcs11Key struct {
+	modulePath string
+	token      string
+	object     string
+}
+
+func parsePKCS11URI(uri string) (pkcs11Key, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return pkcs11Key{}, err
+	}
+	query := u.Query()
+	k := pkcs11Key{modulePath: u.Host + u.Path, token: query.Get("token"), object: query.Get("object")}
+	if k.modulePath == "" || k.token == "" || k.object == "" {
+		return pkcs11Key{}, fmt.Errorf("invalid master key URI %q, use %s<module path>?token=<token label>&object=<key label>", uri, PKCS11Prefix)
+	}
+	return k, nil
+}
+
+func isPKCS11(uri string) bool {
+	return strings.HasPrefix(uri, PKCS11Prefix)
+}
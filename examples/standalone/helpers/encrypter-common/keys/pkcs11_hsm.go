@@ -0,0 +1,158 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build pkcs11
+
+package keys
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+const (
+	gcmIVSize  = 12
+	gcmTagBits = 128
+)
+
+// pkcs11AEAD encrypts with AES-GCM in a PKCS#11 token. Ciphertexts are the IV
+// followed by the ciphertext and tag.
+type pkcs11AEAD struct {
+	// mu serializes the operations, as a PKCS#11 session is not safe for
+	// concurrent use.
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+}
+
+func loadPKCS11(uri string) (tink.AEAD, error) {
+	k, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx := pkcs11.New(k.modulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("can not load the PKCS#11 module %s", k.modulePath)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		return nil, fmt.Errorf("%s: %w", k.modulePath, err)
+	}
+
+	slot, err := findToken(ctx, k.token)
+	if err != nil {
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("token %q: %w", k.token, err)
+	}
+	if pin := os.Getenv("PKCS11_PIN"); pin != "" {
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			ctx.CloseSession(session)
+			return nil, fmt.Errorf("token %q: %w", k.token, err)
+		}
+	}
+
+	key, err := findKey(ctx, session, k.object)
+	if err != nil {
+		ctx.CloseSession(session)
+		return nil, fmt.Errorf("token %q: %w", k.token, err)
+	}
+	return &pkcs11AEAD{ctx: ctx, session: session, key: key}, nil
+}
+
+func findToken(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, err
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no PKCS#11 token with label %q", label)
+}
+
+func findKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch len(objects) {
+	case 0:
+		return 0, fmt.Errorf("no AES key with label %q", label)
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, fmt.Errorf("several AES keys with label %q", label)
+	}
+}
+
+func (a *pkcs11AEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	iv := make([]byte, gcmIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	params := pkcs11.NewGCMParams(iv, associatedData, gcmTagBits)
+	defer params.Free()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.ctx.EncryptInit(a.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, a.key); err != nil {
+		return nil, err
+	}
+	ciphertext, err := a.ctx.Encrypt(a.session, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, ciphertext...), nil
+}
+
+func (a *pkcs11AEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	if len(ciphertext) < gcmIVSize+gcmTagBits/8 {
+		return nil, errors.New("ciphertext too short")
+	}
+	params := pkcs11.NewGCMParams(ciphertext[:gcmIVSize], associatedData, gcmTagBits)
+	defer params.Free()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.ctx.DecryptInit(a.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, a.key); err != nil {
+		return nil, err
+	}
+	return a.ctx.Decrypt(a.session, ciphertext[gcmIVSize:])
+}
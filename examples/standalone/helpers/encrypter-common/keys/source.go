@@ -0,0 +1,327 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keyset sources. A -keyset without a scheme is a local file.
+//
+//	file://<path>
+//	env://<variable>, holding the keyset JSON, or its base64 encoding
+//	gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>], holding the keyset JSON, or its base64 encoding
+//	vault-kv://<mount>/<path>[?version=<version>&field=<field>]
+//
+// Secret Manager sources default to the latest version. Set
+// SECRETMANAGER_EMULATOR_HOST to use a local stand-in without credentials.
+// Vault sources read a KV version 2 secret from VAULT_ADDR with VAULT_TOKEN,
+// the keyset being in the keyset field by default.
+//
+// Keysets fetched from Secret Manager or Vault are cached in KEYSET_CACHE_DIR
+// when set. A pinned version is cached until removed; the latest version for
+// KEYSET_CACHE_TTL, 1h by default, and used past it when the source is
+// unreachable. Cached keysets are wrapped with the master key like the
+// sources.
+const (
+	FileScheme          = "file://"
+	EnvScheme           = "env://"
+	SecretManagerScheme = "gcp-secretmanager://"
+	VaultScheme         = "vault-kv://"
+)
+
+const defaultCacheTTL = time.Hour
+
+// Keyset is the content of a keyset source.
+type Keyset struct {
+	Data []byte `json:"data"`
+	// Version is the version of the secret, empty for files and variables.
+	Version string `json:"version,omitempty"`
+	// Created is the creation time of the keyset file or secret version. A
+	// variable has none, the time it is read stands for it.
+	Created time.Time `json:"created"`
+	// Pinned reports whether the source names a version.
+	Pinned  bool      `json:"pinned,omitempty"`
+	Fetched time.Time `json:"fetched"`
+}
+
+var (
+	fetchedMu sync.Mutex
+	fetched   = make(map[string]*Keyset)
+)
+
+// FetchKeyset reads a keyset source. Sources are read once per process.
+func FetchKeyset(ctx context.Context, source string) (*Keyset, error) {
+	fetchedMu.Lock()
+	defer fetchedMu.Unlock()
+	if k, ok := fetched[source]; ok {
+		return k, nil
+	}
+
+	var k *Keyset
+	var err error
+	switch {
+	case strings.HasPrefix(source, SecretManagerScheme), strings.HasPrefix(source, VaultScheme):
+		k, err = fetchCached(ctx, source)
+	case strings.HasPrefix(source, EnvScheme):
+		k, err = fetchEnv(strings.TrimPrefix(source, EnvScheme))
+	case strings.HasPrefix(source, FileScheme):
+		k, err = fetchFile(strings.TrimPrefix(source, FileScheme))
+	case strings.Contains(source, "://"):
+		err = fmt.Errorf("unknown keyset source %q, use a file, %s, %s, %s or %s", source, FileScheme, EnvScheme, SecretManagerScheme, VaultScheme)
+	default:
+		k, err = fetchFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fetched[source] = k
+	return k, nil
+}
+
+func fetchFile(name string) (*Keyset, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyset{Data: data, Created: info.ModTime().UTC(), Fetched: time.Now().UTC()}, nil
+}
+
+func fetchEnv(name string) (*Keyset, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("environment variable %s of the keyset is not set", name)
+	}
+	data, err := keysetData(value)
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s: %w", name, err)
+	}
+	now := time.Now().UTC()
+	return &Keyset{Data: data, Created: now, Fetched: now}, nil
+}
+
+// keysetData returns a keyset JSON given as is or base64 encoded.
+func keysetData(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		return []byte(value), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("the keyset is neither JSON nor base64 encoded JSON")
+	}
+	return data, nil
+}
+
+// fetchCached fetches a remote source through the cache in KEYSET_CACHE_DIR.
+func fetchCached(ctx context.Context, source string) (*Keyset, error) {
+	dir := os.Getenv("KEYSET_CACHE_DIR")
+	if dir == "" {
+		return fetchRemote(ctx, source)
+	}
+	ttl := defaultCacheTTL
+	if s := os.Getenv("KEYSET_CACHE_TTL"); s != "" {
+		var err error
+		if ttl, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("KEYSET_CACHE_TTL: %w", err)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(source))
+	cacheFile := filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+	var cached *Keyset
+	if b, err := os.ReadFile(cacheFile); err == nil {
+		if err := json.Unmarshal(b, &cached); err != nil {
+			cached = nil
+		}
+	}
+	if cached != nil && (cached.Pinned || time.Since(cached.Fetched) < ttl) {
+		return cached, nil
+	}
+
+	k, err := fetchRemote(ctx, source)
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		log.Printf("%v\nUsing the keyset cached on %s.", err, cached.Fetched.Format(time.RFC3339))
+		return cached, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(cacheFile, b, 0600); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func fetchRemote(ctx context.Context, source string) (*Keyset, error) {
+	if name, ok := strings.CutPrefix(source, SecretManagerScheme); ok {
+		return fetchSecretManager(ctx, name)
+	}
+	return fetchVault(ctx, strings.TrimPrefix(source, VaultScheme))
+}
+
+// getJSON decodes the JSON response to a GET request.
+func getJSON(ctx context.Context, client *http.Client, u string, header http.Header, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func fetchSecretManager(ctx context.Context, name string) (*Keyset, error) {
+	pinned := strings.Contains(name, "/versions/") && !strings.HasSuffix(name, "/versions/latest")
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	endpoint := "https://secretmanager.googleapis.com"
+	client := http.DefaultClient
+	if host := os.Getenv("SECRETMANAGER_EMULATOR_HOST"); host != "" {
+		endpoint = "http://" + host
+	} else {
+		var err error
+		if client, err = httpClient(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var access struct {
+		Name    string `json:"name"`
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}
+	if err := getJSON(ctx, client, endpoint+"/v1/"+name+":access", nil, &access); err != nil {
+		return nil, err
+	}
+	data, err := keysetData(string(access.Payload.Data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", SecretManagerScheme+access.Name, err)
+	}
+	k := &Keyset{
+		Data:    data,
+		Version: path.Base(access.Name),
+		Pinned:  pinned,
+		Fetched: time.Now().UTC(),
+	}
+
+	// Get the creation time of the version accessed, latest being an alias.
+	// Reading it needs secretmanager.versions.get besides access, so without
+	// it the time the keyset is fetched stands for it.
+	var version struct {
+		CreateTime time.Time `json:"createTime"`
+	}
+	if err := getJSON(ctx, client, endpoint+"/v1/"+access.Name, nil, &version); err != nil {
+		log.Printf("Reading the creation time of %s: %v\nUsing the time it was fetched instead.", access.Name, err)
+		k.Created = k.Fetched
+	} else {
+		k.Created = version.CreateTime
+	}
+	return k, nil
+}
+
+func fetchVault(ctx context.Context, source string) (*Keyset, error) {
+	u, err := url.Parse("//" + source)
+	if err != nil {
+		return nil, err
+	}
+	mount, secret := u.Host, strings.Trim(u.Path, "/")
+	if mount == "" || secret == "" {
+		return nil, fmt.Errorf("invalid keyset source %q, use %s<mount>/<path>", VaultScheme+source, VaultScheme)
+	}
+	field := u.Query().Get("field")
+	if field == "" {
+		field = "keyset"
+	}
+	version := u.Query().Get("version")
+
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	secretURL := strings.TrimSuffix(addr, "/") + "/v1/" + mount + "/data/" + secret
+	if version != "" {
+		secretURL += "?version=" + url.QueryEscape(version)
+	}
+
+	var resp struct {
+		Data struct {
+			Data     map[string]string `json:"data"`
+			Metadata struct {
+				CreatedTime time.Time `json:"created_time"`
+				Version     int       `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, http.DefaultClient, secretURL, http.Header{"X-Vault-Token": {token}}, &resp); err != nil {
+		return nil, err
+	}
+	value, ok := resp.Data.Data[field]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s field", VaultScheme+source, field)
+	}
+	data, err := keysetData(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", VaultScheme+source, err)
+	}
+	return &Keyset{
+		Data:    data,
+		Version: strconv.Itoa(resp.Data.Metadata.Version),
+		Created: resp.Data.Metadata.CreatedTime,
+		Pinned:  version != "",
+		Fetched: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/daead"
+	"github.com/tink-crypto/tink-go/v2/insecurecleartextkeyset"
+	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/prf"
+	"google.golang.org/protobuf/proto"
+)
+
+// Key types of the keysets, named after their tinkey key templates.
+// AES256_GCM keysets encrypt the data, AES256_SIV and HMAC_SHA256_PRF ones
+// derive blind indexes, format-preserving encryption keys and date offsets.
+var KeyTypes = map[string]string{
+	"AES256_GCM":      "type.googleapis.com/google.crypto.tink.AesGcmKey",
+	"AES256_SIV":      "type.googleapis.com/google.crypto.tink.AesSivKey",
+	"HMAC_SHA256_PRF": "type.googleapis.com/google.crypto.tink.HmacPrfKey",
+}
+
+// Expect is what VerifyKeyset checks a keyset against.
+type Expect struct {
+	// KeyType is a key of KeyTypes the primary key must have.
+	KeyType string
+	// Fingerprint is the fingerprint the keyset is pinned to, if any.
+	Fingerprint string
+}
+
+// Fingerprint identifies the key material of a keyset, and changes when the
+// keyset is swapped or rotated but not when it is wrapped again. It is
+// "sha256:" followed by the hex SHA-256 of the serialized keyset.
+func Fingerprint(handle *keyset.Handle) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(insecurecleartextkeyset.KeysetMaterial(handle))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyKeyset reads a keyset and checks, before any data is processed, that
+// it unwraps with the master key, that its primary key has the expected type,
+// that it passes a self-test and that it matches the pinned fingerprint. The
+// errors tell what to fix.
+func VerifyKeyset(ctx context.Context, keysetFile, masterKeyURI string, want Expect) (*keyset.Handle, error) {
+	typeURL, ok := KeyTypes[want.KeyType]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type %q, want one of %s", want.KeyType, strings.Join(keyTypeNames(), ", "))
+	}
+
+	// Errors reading the source, like a missing file or an unreachable
+	// secret, are returned as they are.
+	k, err := FetchKeyset(ctx, keysetFile)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(k.Data) {
+		return nil, fmt.Errorf("keyset %s is not JSON\nCheck it is a keyset written by tinkey with a master key", keysetFile)
+	}
+	handle, err := unwrapKeyset(ctx, k.Data, masterKeyURI)
+	if err != nil {
+		return nil, fmt.Errorf("keyset %s does not unwrap with master key %s: %w\nCheck -master-key-uri is the key encryption key the keyset was wrapped with, and that the identity used may decrypt with it", keysetFile, masterKeyURI, err)
+	}
+
+	info := handle.KeysetInfo()
+	for _, k := range info.GetKeyInfo() {
+		if k.GetKeyId() != info.GetPrimaryKeyId() {
+			continue
+		}
+		if k.GetTypeUrl() != typeURL {
+			return nil, fmt.Errorf("primary key %d of keyset %s is a %s, want %s\nThe keyset was created for another use, create one with tinkey create-keyset --key-template %s", k.GetKeyId(), keysetFile, k.GetTypeUrl(), typeURL, want.KeyType)
+		}
+	}
+
+	if err := selfTest(handle, want.KeyType); err != nil {
+		return nil, fmt.Errorf("keyset %s fails the self-test: %w", keysetFile, err)
+	}
+
+	fingerprint, err := Fingerprint(handle)
+	if err != nil {
+		return nil, err
+	}
+	if want.Fingerprint != "" && want.Fingerprint != fingerprint {
+		return nil, fmt.Errorf("keyset %s has fingerprint %s, want %s\nThe keyset was swapped or rotated. Check it was meant to be, then pin the new fingerprint", keysetFile, fingerprint, want.Fingerprint)
+	}
+	log.Printf("Keyset %s verified, fingerprint %s.", keysetFile, fingerprint)
+	return handle, nil
+}
+
+// selfTest checks the keyset works, round-tripping a message for the
+// encryption keysets.
+func selfTest(handle *keyset.Handle, keyType string) error {
+	msg, ad := []byte("verify-keyset self-test"), []byte("verify-keyset")
+	switch keyType {
+	case "AES256_GCM":
+		a, err := aead.New(handle)
+		if err != nil {
+			return err
+		}
+		ct, err := a.Encrypt(msg, ad)
+		if err != nil {
+			return err
+		}
+		pt, err := a.Decrypt(ct, ad)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(pt, msg) {
+			return errors.New("decrypted message differs")
+		}
+	case "AES256_SIV":
+		d, err := daead.New(handle)
+		if err != nil {
+			return err
+		}
+		ct, err := d.EncryptDeterministically(msg, ad)
+		if err != nil {
+			return err
+		}
+		pt, err := d.DecryptDeterministically(ct, ad)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(pt, msg) {
+			return errors.New("decrypted message differs")
+		}
+	case "HMAC_SHA256_PRF":
+		p, err := prf.NewPRFSet(handle)
+		if err != nil {
+			return err
+		}
+		if _, err := p.ComputePrimaryPRF(msg, 32); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func keyTypeNames() []string {
+	var names []string
+	for name := range KeyTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,246 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ledger keeps per-key usage counters of the data encryption keys in
+// a local file, so keys past their safe usage bound or the key age policy
+// are flagged at encryption time and rotated.
+//
+// Keys are identified by the key ID Tink writes at the start of ciphertexts.
+// Tink keysets have no creation time, so a key is dated by the modification
+// time of its keyset file when first seen, or by the time the ledger first
+// recorded it.
+package ledger
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"encrypter-common/internal/flock"
+)
+
+// DefaultMaxMessages is the number of messages a AES-GCM key with random
+// 96-bit nonces can safely encrypt, from NIST SP 800-38D.
+const DefaultMaxMessages = 1 << 32
+
+// Policy sets the limits of a key.
+type Policy struct {
+	// MaxMessages is the number of messages a key may encrypt, 0 for no limit.
+	MaxMessages uint64
+	// MaxAge is the age past which a key may not be used, 0 for no limit.
+	MaxAge time.Duration
+	// Refuse makes exceeded limits fail the encryption instead of logging a
+	// warning.
+	Refuse bool
+}
+
+// Usage is the usage of a key.
+type Usage struct {
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+	Messages uint64    `json:"messages"`
+}
+
+// LimitError is returned when a key exceeds a limit with a refusing policy.
+type LimitError struct {
+	KeyID  uint32
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("key %d %s, rotate the keyset", e.KeyID, e.Reason)
+}
+
+// Ledger counts the messages encrypted by each key.
+type Ledger struct {
+	path   string
+	policy Policy
+	keys   map[uint32]*Usage
+	// delta counts the messages of this run, added to the file on Save so
+	// concurrent runs do not lose each other's counts.
+	delta  map[uint32]uint64
+	warned map[uint32]bool
+}
+
+// Open reads the ledger file at path, which does not need to exist yet.
+func Open(path string, policy Policy) (*Ledger, error) {
+	l := &Ledger{path: path, policy: policy, delta: make(map[uint32]uint64), warned: make(map[uint32]bool)}
+	keys, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	l.keys = keys
+	return l, nil
+}
+
+func read(path string) (map[uint32]*Usage, error) {
+	keys := make(map[uint32]*Usage)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Keys map[string]*Usage `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for id, u := range file.Keys {
+		keyID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid key ID %q", path, id)
+		}
+		keys[uint32(keyID)] = u
+	}
+	return keys, nil
+}
+
+// KeyID returns the key ID of a Tink ciphertext, and false for ciphertexts
+// without one, i.e. of RAW keys.
+func KeyID(ciphertext []byte) (uint32, bool) {
+	// TINK and LEGACY prefixes are a version byte and the key ID.
+	if len(ciphertext) < 5 || (ciphertext[0] != 0x01 && ciphertext[0] != 0x00) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(ciphertext[1:5]), true
+}
+
+// Check registers a key, dated created if that is earlier than the ledger
+// knows, and checks it against the policy.
+func (l *Ledger) Check(keyID uint32, created time.Time) error {
+	created = created.Truncate(time.Second)
+	u, ok := l.keys[keyID]
+	if !ok {
+		u = &Usage{Created: created}
+		l.keys[keyID] = u
+	}
+	if created.Before(u.Created) {
+		u.Created = created
+	}
+	return l.check(keyID, u)
+}
+
+func (l *Ledger) check(keyID uint32, u *Usage) error {
+	var reason string
+	switch {
+	case l.policy.MaxMessages > 0 && u.Messages >= l.policy.MaxMessages:
+		reason = fmt.Sprintf("encrypted %d messages, the limit is %d", u.Messages, l.policy.MaxMessages)
+	case l.policy.MaxAge > 0 && time.Since(u.Created) > l.policy.MaxAge:
+		reason = fmt.Sprintf("was created on %s, older than %d days", u.Created.Format(time.DateOnly), int(l.policy.MaxAge.Hours()/24))
+	default:
+		return nil
+	}
+	if l.policy.Refuse {
+		return &LimitError{KeyID: keyID, Reason: reason}
+	}
+	if !l.warned[keyID] {
+		l.warned[keyID] = true
+		log.Printf("warning: key %d %s, rotate the keyset", keyID, reason)
+	}
+	return nil
+}
+
+// Record counts a message encrypted into ciphertext, and checks its key
+// against the policy.
+func (l *Ledger) Record(ciphertext []byte) error {
+	keyID, ok := KeyID(ciphertext)
+	if !ok {
+		return nil
+	}
+	u, ok := l.keys[keyID]
+	if !ok {
+		u = &Usage{Created: time.Now().UTC().Truncate(time.Second)}
+		l.keys[keyID] = u
+	}
+	if err := l.check(keyID, u); err != nil {
+		return err
+	}
+	u.Messages++
+	u.LastUsed = time.Now().UTC().Truncate(time.Second)
+	l.delta[keyID]++
+	return nil
+}
+
+// Save adds the usage of this run to the ledger file. A lock file next to it
+// is held from reading the file to replacing it, so concurrent runs add to
+// each other's counts.
+func (l *Ledger) Save() error {
+	lockFile, err := os.OpenFile(l.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	if err := flock.Lock(lockFile); err != nil {
+		return fmt.Errorf("locking %s: %w", lockFile.Name(), err)
+	}
+	defer flock.Unlock(lockFile)
+
+	keys, err := read(l.path)
+	if err != nil {
+		return err
+	}
+	for keyID, u := range l.keys {
+		saved, ok := keys[keyID]
+		if !ok {
+			keys[keyID] = &Usage{Created: u.Created, LastUsed: u.LastUsed, Messages: l.delta[keyID]}
+			continue
+		}
+		saved.Messages += l.delta[keyID]
+		if u.Created.Before(saved.Created) {
+			saved.Created = u.Created
+		}
+		if u.LastUsed.After(saved.LastUsed) {
+			saved.LastUsed = u.LastUsed
+		}
+	}
+
+	file := struct {
+		Keys map[string]*Usage `json:"keys"`
+	}{Keys: make(map[string]*Usage, len(keys))}
+	for keyID, u := range keys {
+		file.Keys[strconv.FormatUint(uint64(keyID), 10)] = u
+	}
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+	clear(l.delta)
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package output writes encrypted records as CSV, newline-delimited JSON or
+// Avro, with every column as a string to match the BigQuery table schema.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+const (
+	CSV  = "csv"
+	JSON = "json"
+	Avro = "avro"
+)
+
+// avroBlockSize is the number of records buffered in each Avro data block.
+const avroBlockSize = 1000
+
+var avroName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Writer writes records that have one value per column.
+type Writer interface {
+	// Write writes a single record.
+	Write(record []string) error
+	// Close flushes buffered records. It does not close the underlying writer.
+	Close() error
+}
+
+// NewWriter returns a Writer for format, one of csv, json or avro.
+func NewWriter(w io.Writer, format string, columns []string) (Writer, error) {
+	switch strings.ToLower(format) {
+	case CSV:
+		return newCsvWriter(w, columns)
+	case JSON:
+		return &jsonWriter{enc: json.NewEncoder(w), columns: columns}, nil
+	case Avro:
+		return newAvroWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("invalid output format %q, must be one of: csv, json, avro", format)
+	}
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCsvWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(columns); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (c *csvWriter) Write(record []string) error {
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonWriter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func (j *jsonWriter) Write(record []string) error {
+	line := make(map[string]string, len(j.columns))
+	for i, column := range j.columns {
+		line[column] = record[i]
+	}
+	return j.enc.Encode(line)
+}
+
+func (j *jsonWriter) Close() error {
+	return nil
+}
+
+// avroSchema returns a record schema with a string field for every column,
+// in the same shape as templates/avro.schema.template.
+func avroSchema(columns []string) (string, error) {
+	type field struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	schema := struct {
+		Type   string  `json:"type"`
+		Name   string  `json:"name"`
+		Fields []field `json:"fields"`
+	}{Type: "record", Name: "Avro"}
+
+	for _, column := range columns {
+		if !avroName.MatchString(column) {
+			return "", fmt.Errorf("column %q is not a valid Avro field name", column)
+		}
+		schema.Fields = append(schema.Fields, field{Name: column, Type: "string"})
+	}
+
+	b, err := json.Marshal(schema)
+	return string(b), err
+}
+
+type avroWriter struct {
+	ocf     *goavro.OCFWriter
+	columns []string
+	block   []interface{}
+}
+
+func newAvroWriter(w io.Writer, columns []string) (*avroWriter, error) {
+	schema, err := avroSchema(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               w,
+		Schema:          schema,
+		CompressionName: goavro.CompressionDeflateLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &avroWriter{ocf: ocf, columns: columns}, nil
+}
+
+func (a *avroWriter) Write(record []string) error {
+	datum := make(map[string]interface{}, len(a.columns))
+	for i, column := range a.columns {
+		datum[column] = record[i]
+	}
+
+	a.block = append(a.block, datum)
+	if len(a.block) < avroBlockSize {
+		return nil
+	}
+	return a.flush()
+}
+
+func (a *avroWriter) flush() error {
+	if len(a.block) == 0 {
+		return nil
+	}
+	err := a.ocf.Append(a.block)
+	a.block = a.block[:0]
+	return err
+}
+
+func (a *avroWriter) Close() error {
+	return a.flush()
+}
@@ -0,0 +1,76 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package padding pads plaintext to fixed size buckets before encryption, so
+// the ciphertext length does not reveal the plaintext length. The padding is
+// a run of Marker characters at the end of the value, which the decrypted view
+// in templates/decrypted_view.template strips from the padded columns.
+package padding
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Marker is the padding character, the ASCII unit separator.
+const Marker = "\x1f"
+
+// Field is a column padded to the sizes in bytes of Buckets, in ascending order.
+type Field struct {
+	Column  string
+	Buckets []int
+}
+
+// ParseFields parses a comma-separated list of column:bucket[:bucket...],
+// i.e. "Card_Holders_Name:32:64,Card_PIN:8".
+func ParseFields(spec string) ([]Field, error) {
+	var fields []Field
+	for _, s := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(s), ":")
+		if parts[0] == "" || len(parts) < 2 {
+			return nil, fmt.Errorf("pad field %q: expected column:bucket[:bucket...]", s)
+		}
+		f := Field{Column: parts[0]}
+		for _, p := range parts[1:] {
+			size, err := strconv.Atoi(p)
+			if err != nil || size < 1 {
+				return nil, fmt.Errorf("pad field %q: invalid bucket size %q", s, p)
+			}
+			f.Buckets = append(f.Buckets, size)
+		}
+		sort.Ints(f.Buckets)
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Pad pads value with Marker to the smallest bucket that fits it. Values
+// longer than the largest bucket are padded to a multiple of it.
+func (f Field) Pad(value string) string {
+	n := len(value)
+	size := 0
+	for _, b := range f.Buckets {
+		if n <= b {
+			size = b
+			break
+		}
+	}
+	if size == 0 {
+		largest := f.Buckets[len(f.Buckets)-1]
+		size = (n + largest - 1) / largest * largest
+	}
+	return value + strings.Repeat(Marker, size-n)
+}
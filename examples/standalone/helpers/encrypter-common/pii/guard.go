@@ -0,0 +1,124 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pii
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Guard runs the detectors over the columns that are written unencrypted, so a
+// run can be refused when one of them holds PII.
+type Guard struct {
+	minLikelihood Likelihood
+	sampleSize    int
+	records       int
+	checked       bool
+	allow         map[string]bool
+	columns       map[string]*Column
+	order         []string
+}
+
+// Violation is an unencrypted column found to hold PII.
+type Violation struct {
+	Column string
+	Finding
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s (%s, %d of %d values)", v.Column, v.InfoType, v.Likelihood, v.Matches, v.Samples)
+}
+
+// ViolationError is returned when unencrypted columns hold PII.
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	lines := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		lines[i] = "  " + v.String()
+	}
+	return "unencrypted columns contain PII:\n" + strings.Join(lines, "\n")
+}
+
+// NewGuard returns a Guard that reports findings of at least minLikelihood in
+// the first sampleSize records, or all records when sampleSize is 0. allow
+// lists known false positives as "column" or "column:INFO_TYPE", case-insensitive.
+func NewGuard(minLikelihood Likelihood, sampleSize int, allow []string) *Guard {
+	g := &Guard{
+		minLikelihood: minLikelihood,
+		sampleSize:    sampleSize,
+		allow:         make(map[string]bool),
+		columns:       make(map[string]*Column),
+	}
+	for _, a := range allow {
+		if a = strings.TrimSpace(a); a != "" {
+			g.allow[strings.ToLower(a)] = true
+		}
+	}
+	return g
+}
+
+// Add runs the detectors on a value of an unencrypted column of the current record.
+func (g *Guard) Add(name, value string) {
+	if g.checked || g.allow[strings.ToLower(name)] {
+		return
+	}
+	column, ok := g.columns[name]
+	if !ok {
+		column = NewColumn(name)
+		g.columns[name] = column
+		g.order = append(g.order, name)
+	}
+	column.Add(value)
+}
+
+// EndRecord finishes the current record. Once the sample is complete it
+// returns a *ViolationError if unencrypted columns hold PII.
+func (g *Guard) EndRecord() error {
+	if g.checked {
+		return nil
+	}
+	g.records++
+	if g.sampleSize > 0 && g.records >= g.sampleSize {
+		return g.Check()
+	}
+	return nil
+}
+
+// Check returns a *ViolationError if the columns sampled so far hold PII.
+// Later records are not sampled.
+func (g *Guard) Check() error {
+	if g.checked {
+		return nil
+	}
+	g.checked = true
+
+	var violations []Violation
+	for _, name := range g.order {
+		for _, f := range g.columns[name].Findings() {
+			if f.Likelihood < g.minLikelihood || g.allow[strings.ToLower(name+":"+f.InfoType)] {
+				continue
+			}
+			violations = append(violations, Violation{Column: name, Finding: f})
+			break
+		}
+	}
+	if len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pii detects personal and payment card data in column values, so
+// columns can be encrypted before the data reaches BigQuery. Info type and
+// likelihood names follow Cloud DLP.
+package pii
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Likelihood is how likely a column holds an info type.
+type Likelihood int
+
+const (
+	VeryUnlikely Likelihood = iota
+	Unlikely
+	Possible
+	Likely
+	VeryLikely
+)
+
+var likelihoodNames = []string{"VERY_UNLIKELY", "UNLIKELY", "POSSIBLE", "LIKELY", "VERY_LIKELY"}
+
+func (l Likelihood) String() string {
+	return likelihoodNames[l]
+}
+
+// ParseLikelihood parses a likelihood name such as "LIKELY".
+func ParseLikelihood(name string) (Likelihood, error) {
+	for i, n := range likelihoodNames {
+		if strings.EqualFold(strings.ReplaceAll(name, "-", "_"), n) {
+			return Likelihood(i), nil
+		}
+	}
+	return VeryUnlikely, fmt.Errorf("unknown likelihood %q, use one of %s", name, strings.Join(likelihoodNames, ", "))
+}
+
+// Detector finds one info type.
+type Detector struct {
+	InfoType string
+	// hint matches column names that usually hold the info type.
+	hint *regexp.Regexp
+	// weak detectors match many unrelated values, so without a column name
+	// hint they never report more than Possible.
+	weak bool
+	// distinct detectors expect mostly distinct values. Columns with few
+	// distinct values, such as product names, are downgraded.
+	distinct bool
+	match    func(string) bool
+}
+
+// Detectors are the built-in detectors.
+var Detectors = []Detector{
+	{
+		InfoType: "CREDIT_CARD_NUMBER",
+		hint:     regexp.MustCompile(`(?i)card.?(num|no\b)|credit.?card|\bpan\b|cc.?num`),
+		match:    isCardNumber,
+	},
+	{
+		InfoType: "CVV",
+		hint:     regexp.MustCompile(`(?i)cvv|cvc|\bcid\b|csc|security.?code`),
+		weak:     true,
+		match:    regexp.MustCompile(`^\d{3,4}$`).MatchString,
+	},
+	{
+		InfoType: "US_SOCIAL_SECURITY_NUMBER",
+		hint:     regexp.MustCompile(`(?i)ssn|social.?sec`),
+		match:    isSSN,
+	},
+	{
+		InfoType: "EMAIL_ADDRESS",
+		hint:     regexp.MustCompile(`(?i)e.?mail`),
+		match:    regexp.MustCompile(`^[A-Za-z0-9._%+'-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`).MatchString,
+	},
+	{
+		InfoType: "PERSON_NAME",
+		hint:     regexp.MustCompile(`(?i)holder|person|customer|patient|first.?name|last.?name|given.?name|surname|full.?name|^name$`),
+		weak:     true,
+		distinct: true,
+		match:    isPersonName,
+	},
+	{
+		InfoType: "DATE",
+		hint:     regexp.MustCompile(`(?i)date|birth|dob|expir|_dt$`),
+		match:    isDate,
+	},
+}
+
+// Finding is what a detector found in the sampled values of a column.
+type Finding struct {
+	InfoType   string
+	Likelihood Likelihood
+	Matches    int
+	Samples    int
+}
+
+// maxDistinct bounds the memory used to count distinct values.
+const maxDistinct = 10000
+
+// Column accumulates detector matches over the values of a column.
+type Column struct {
+	Name     string
+	samples  int
+	matches  []int
+	distinct map[string]bool
+}
+
+// NewColumn returns an empty Column.
+func NewColumn(name string) *Column {
+	return &Column{Name: name, matches: make([]int, len(Detectors)), distinct: make(map[string]bool)}
+}
+
+// Add runs the detectors on a value. Empty values are ignored.
+func (c *Column) Add(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	c.samples++
+	if len(c.distinct) < maxDistinct {
+		c.distinct[value] = true
+	}
+	for i, d := range Detectors {
+		if d.match(value) {
+			c.matches[i]++
+		}
+	}
+}
+
+// Samples returns the number of non-empty values added to the column.
+func (c *Column) Samples() int {
+	return c.samples
+}
+
+// Findings returns the info types found in the column, most likely first.
+func (c *Column) Findings() []Finding {
+	var findings []Finding
+	for i, d := range Detectors {
+		hinted := d.hint.MatchString(c.Name)
+		if c.matches[i] == 0 && !hinted {
+			continue
+		}
+		findings = append(findings, Finding{
+			InfoType:   d.InfoType,
+			Likelihood: c.likelihood(d, c.matches[i], hinted),
+			Matches:    c.matches[i],
+			Samples:    c.samples,
+		})
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Likelihood > findings[j].Likelihood
+	})
+	return findings
+}
+
+// Top returns the most likely finding of the column, if any.
+func (c *Column) Top() (Finding, bool) {
+	findings := c.Findings()
+	if len(findings) == 0 {
+		return Finding{}, false
+	}
+	return findings[0], true
+}
+
+func (c *Column) likelihood(d Detector, matches int, hinted bool) Likelihood {
+	if matches == 0 {
+		return Unlikely
+	}
+
+	ratio := float64(matches) / float64(c.samples)
+	var l Likelihood
+	switch {
+	case ratio >= 0.9:
+		l = VeryLikely
+	case ratio >= 0.6:
+		l = Likely
+	case ratio >= 0.3:
+		l = Possible
+	default:
+		l = Unlikely
+	}
+
+	// A column name hint only counts when most values match, so a
+	// "Card_Type_Full_Name" column with a few two-word values is not a name.
+	if hinted && ratio >= 0.6 {
+		l++
+	} else if d.weak && l > Possible {
+		l = Possible
+	}
+	if d.distinct && matches >= 20 && len(c.distinct) < maxDistinct && float64(len(c.distinct)) < 0.2*float64(matches) {
+		l -= 2
+	}
+	return max(VeryUnlikely, min(l, VeryLikely))
+}
+
+// Luhn reports whether digits pass the Luhn checksum. Spaces and dashes are ignored.
+func Luhn(digits string) bool {
+	sum, n := 0, 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		ch := digits[i]
+		if ch == ' ' || ch == '-' {
+			continue
+		}
+		if ch < '0' || ch > '9' {
+			return false
+		}
+		d := int(ch - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+var cardNumber = regexp.MustCompile(`^\d(?:[ -]?\d){12,18}$`)
+
+func isCardNumber(value string) bool {
+	return cardNumber.MatchString(value) && Luhn(value)
+}
+
+var ssn = regexp.MustCompile(`^(\d{3})([- ]?)(\d{2})([- ]?)(\d{4})$`)
+
+func isSSN(value string) bool {
+	m := ssn.FindStringSubmatch(value)
+	if m == nil || m[2] != m[4] {
+		return false
+	}
+	area, group, serial := m[1], m[3], m[5]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// isPersonName matches two to four capitalized words, such as "Mary Ann Smith".
+func isPersonName(value string) bool {
+	words := strings.Fields(value)
+	if len(words) < 2 || len(words) > 4 {
+		return false
+	}
+	for _, w := range words {
+		runes := []rune(strings.TrimSuffix(w, "."))
+		if len(runes) == 0 || !unicode.IsUpper(runes[0]) {
+			return false
+		}
+		for _, r := range runes[1:] {
+			if !unicode.IsLetter(r) && r != '\'' && r != '-' && r != '’' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"01/02/2006",
+	"02/01/2006",
+	"01-02-2006",
+	"02.01.2006",
+	"01/2006",
+	"2006-01",
+	"01/06",
+	"Jan 2, 2006",
+	"2 Jan 2006",
+	"January 2, 2006",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+}
+
+func isDate(value string) bool {
+	if len(value) < 5 || len(value) > 25 {
+		return false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil && t.Year() >= 1900 && t.Year() <= 2100 {
+			return true
+		}
+	}
+	return false
+}
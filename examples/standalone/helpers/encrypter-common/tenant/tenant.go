@@ -0,0 +1,282 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tenant keeps one data encryption keyset per tenant, wrapped with
+// the master key, so the data of a single tenant can be crypto-shredded by
+// destroying its keyset instead of rewriting every table that holds it.
+//
+// Keysets are stored in a directory, one file per tenant named after an
+// HMAC-SHA256 of the tenant ID under the names keyset of the directory, which
+// is wrapped with the master key too, so file names do not reveal tenants
+// without access to the master key. The files have the tinkey format and can
+// be used like the -keyset of the encrypters. Key IDs are unique within the
+// directory, so keyset-chain can pick the keyset of a ciphertext by key ID.
+package tenant
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/prf"
+	tinkpb "github.com/tink-crypto/tink-go/v2/proto/tink_go_proto"
+	"github.com/tink-crypto/tink-go/v2/tink"
+
+	"encrypter-common/keys"
+)
+
+// ErrShredded is returned for tenants whose keyset was shredded.
+var ErrShredded = errors.New("tenant keyset was shredded")
+
+// NamesKeyset is the file name of the HMAC_SHA256_PRF keyset that keyset
+// file names are derived from.
+const NamesKeyset = "names.keyset.json"
+
+// nameLength is the number of bytes of the HMAC in keyset file names.
+const nameLength = 32
+
+// Store reads and creates the keysets of tenants.
+type Store struct {
+	dir          string
+	masterKeyURI string
+	masterKey    tink.AEAD
+	names        *prf.Set
+	aeads        map[string]tink.AEAD
+}
+
+// Open opens the keyset store in dir, creating the directory and its names
+// keyset if needed. Keysets are wrapped with the first master key of
+// masterKeyURI, a comma-separated list.
+func Open(ctx context.Context, dir, masterKeyURI string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	uris := keys.MasterKeyURIs(masterKeyURI)
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("master key URI is empty")
+	}
+	masterKey, err := keys.LoadMasterKey(ctx, uris[0])
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, masterKeyURI: uris[0], masterKey: masterKey, aeads: make(map[string]tink.AEAD)}
+
+	handle, err := s.readKeyset(filepath.Join(dir, NamesKeyset))
+	if errors.Is(err, fs.ErrNotExist) {
+		handle, err = s.createKeyset(filepath.Join(dir, NamesKeyset), prf.HMACSHA256PRFKeyTemplate())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", NamesKeyset, err)
+	}
+	if s.names, err = prf.NewPRFSet(handle); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MasterKeyURI returns the URI of the master key the keysets are wrapped with.
+func (s *Store) MasterKeyURI() string {
+	return s.masterKeyURI
+}
+
+// KeysetFile returns the name of the keyset file of a tenant.
+func (s *Store) KeysetFile(tenant string) (string, error) {
+	sum, err := s.names.ComputePrimaryPRF([]byte(tenant), nameLength)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, hex.EncodeToString(sum)+".json"), nil
+}
+
+// shreddedFile returns the name of the file that records that a keyset was
+// shredded, so it is not created again.
+func shreddedFile(keysetFile string) string {
+	return keysetFile + ".shredded"
+}
+
+// Keysets returns the tenant keyset files of dir.
+func Keysets(dir string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var keysets []string
+	for _, name := range names {
+		if _, err := hex.DecodeString(strings.TrimSuffix(filepath.Base(name), ".json")); err == nil {
+			keysets = append(keysets, name)
+		}
+	}
+	return keysets, nil
+}
+
+// PrimaryKeyID returns the primary key ID of a keyset file, which is stored
+// unencrypted.
+func PrimaryKeyID(keysetFile string) (uint32, error) {
+	b, err := os.ReadFile(keysetFile)
+	if err != nil {
+		return 0, err
+	}
+	var k struct {
+		KeysetInfo struct {
+			PrimaryKeyID uint32 `json:"primaryKeyId"`
+		} `json:"keysetInfo"`
+	}
+	if err := json.Unmarshal(b, &k); err != nil {
+		return 0, fmt.Errorf("%s: %w", keysetFile, err)
+	}
+	return k.KeysetInfo.PrimaryKeyID, nil
+}
+
+// keyIDs returns the primary key IDs of the tenant keysets of dir.
+func keyIDs(dir string) (map[uint32]bool, error) {
+	names, err := Keysets(dir)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[uint32]bool)
+	for _, name := range names {
+		keyID, err := PrimaryKeyID(name)
+		if err != nil {
+			return nil, err
+		}
+		used[keyID] = true
+	}
+	return used, nil
+}
+
+// AEAD returns the primitive of the keyset of a tenant. The keyset is
+// generated with the AES256_GCM key template on first use.
+func (s *Store) AEAD(tenant string) (tink.AEAD, error) {
+	if a, ok := s.aeads[tenant]; ok {
+		return a, nil
+	}
+	if tenant == "" {
+		return nil, fmt.Errorf("tenant is empty")
+	}
+	name, err := s.KeysetFile(tenant)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(shreddedFile(name)); err == nil {
+		return nil, fmt.Errorf("tenant %q: %w", tenant, ErrShredded)
+	}
+
+	handle, err := s.readKeyset(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		handle, err = s.createKeyset(name, aead.AES256GCMKeyTemplate())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tenant %q: %w", tenant, err)
+	}
+	a, err := aead.New(handle)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[tenant] = a
+	return a, nil
+}
+
+func (s *Store) readKeyset(name string) (*keyset.Handle, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return keyset.Read(keyset.NewJSONReader(f), s.masterKey)
+}
+
+// createKeyset writes a new keyset. The file is created exclusively, so
+// concurrent runs do not overwrite each other's keyset.
+func (s *Store) createKeyset(name string, template *tinkpb.KeyTemplate) (*keyset.Handle, error) {
+	used, err := keyIDs(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var handle *keyset.Handle
+	// Key IDs are random, make them unique in the directory so the keyset of
+	// a ciphertext is unambiguous.
+	for handle == nil || used[handle.KeysetInfo().GetPrimaryKeyId()] {
+		if handle, err = keyset.NewHandle(template); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return s.readKeyset(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := handle.Write(keyset.NewJSONWriter(f), s.masterKey); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return handle, nil
+}
+
+// Shred destroys the keyset of a tenant, so the tenant's ciphertexts can no
+// longer be decrypted, and records it so the keyset is not created again. The
+// file is overwritten before it is removed, but copies of it, such as backups,
+// object versions or KEYSET_CHAIN expressions, must be deleted too.
+func (s *Store) Shred(tenant string) error {
+	name, err := s.KeysetFile(tenant)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(shreddedFile(name)); err == nil {
+			return fmt.Errorf("tenant %q: %w", tenant, ErrShredded)
+		}
+		return fmt.Errorf("tenant %q has no keyset in %s", tenant, s.dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(make([]byte, info.Size())); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(shreddedFile(name), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,320 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/tink"
+	"golang.org/x/text/encoding"
+
+	"encrypter-common/audit"
+	"encrypter-common/charset"
+	"encrypter-common/compress"
+	"encrypter-common/fixedwidth"
+	"encrypter-common/keys"
+	"encrypter-common/ledger"
+	"encrypter-common/output"
+)
+
+var (
+	encrypter tink.AEAD
+	auditRun  *audit.Run
+	usage     *ledger.Ledger
+)
+
+// generator config
+type genCfg struct {
+	in                string
+	out               string
+	outFormat         string
+	copybook          string
+	layout            string
+	fields            string
+	keyset            string
+	keysetFingerprint string
+	masterKeyURI      string
+	credentials       keys.Credentials
+	keyLedger         string
+	keyMaxMessages    uint64
+	keyMaxAgeDays     int
+	keyLimitAction    string
+	auditLog          string
+	compress          string
+	inputEncoding     string
+	recordFormat      string
+	recordLength      int
+}
+
+func parseFlags() genCfg {
+	var c genCfg
+	flag.StringVar(&c.in, "in", "", "Filename to read fixed-width records.")
+	flag.StringVar(&c.out, "out", "", "Filename to write encrypted data.")
+	flag.StringVar(&c.outFormat, "out-format", "csv", "The output format: csv, json or avro. All values are written as strings.")
+	flag.StringVar(&c.copybook, "copybook", "", "COBOL copybook describing the records. Field names are written with hyphens replaced by underscores.")
+	flag.StringVar(&c.layout, "layout", "", "Layout file describing the records, used instead of -copybook. A csv file with the header \"name,start,length,type,scale,signed\", where start is 1-based and type is string, zoned, packed or binary.")
+	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of field names that need to be encrypted. i.e. \"CARD_NUMBER,CARD_PIN\"")
+	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data, or a keyset source: file://<path>, env://<variable> holding the keyset JSON or its base64 encoding, gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>] or vault-kv://<mount>/<path>[?version=<version>&field=<field>], i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset/versions/3\". Set KEYSET_CACHE_DIR to cache keysets fetched from Secret Manager or Vault.")
+	flag.StringVar(&c.keysetFingerprint, "keyset-fingerprint", "", "Fingerprint the keyset is pinned to, as logged by earlier runs or printed by verify-keyset. The run stops before encrypting any record if the keyset does not match, i.e. it was swapped. i.e. \"sha256:3b4c...\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.keyLedger, "key-usage-ledger", "", "Filename of a ledger counting the messages encrypted by each key ID, kept across runs. Keys are checked against -key-max-messages and -key-max-age-days. Disabled when empty.")
+	flag.Uint64Var(&c.keyMaxMessages, "key-max-messages", ledger.DefaultMaxMessages, "Number of messages a key may encrypt before it must be rotated, 0 for no limit. The default is the AES-GCM bound for random nonces.")
+	flag.IntVar(&c.keyMaxAgeDays, "key-max-age-days", 365, "Age in days past which a key must be rotated, 0 for no limit. A key is dated by the modification time of its keyset file when first recorded in the ledger.")
+	flag.StringVar(&c.keyLimitAction, "key-limit-action", "warn", "What to do when a key exceeds -key-max-messages or -key-max-age-days: warn, or refuse to encrypt.")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set. Compressed input is always detected automatically.")
+	flag.StringVar(&c.inputEncoding, "input-encoding", "utf-8", "Character set of the text fields. i.e. cp037 (EBCDIC), cp1047, cp1140, utf-8 or latin1.")
+	flag.StringVar(&c.recordFormat, "record-format", "", "How records are stored: lines (newline separated), fixed (RECFM=F) or variable (RECFM=V, with record descriptor words). Defaults to fixed for EBCDIC input or layouts with packed or binary fields, and lines otherwise. Layouts with packed or binary fields can not be read as lines.")
+	flag.IntVar(&c.recordLength, "record-length", 0, "Record length in bytes. Defaults to the length of the layout.")
+	flag.Parse()
+	if c.fields == "" {
+		log.Fatal("fields flag is missing. Please set fields flag that is a comma-separated list of field names that need to be encrypted. i.e. -fields \"CARD_NUMBER,CARD_PIN\"")
+	}
+	if (c.copybook == "") == (c.layout == "") {
+		log.Fatal("Exactly one of copybook or layout must be set.")
+	}
+	if c.keyset == "" {
+		log.Fatal("Keyset filename to be used to encrypt the data is missing.")
+	}
+	if c.keyLimitAction != "warn" && c.keyLimitAction != "refuse" {
+		log.Fatalf("invalid key-limit-action %q, use warn or refuse.", c.keyLimitAction)
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	if c.in == "" {
+		log.Fatal("Input filename is missing.")
+	}
+	if c.out == "" {
+		log.Fatal("Output filename is missing.")
+	}
+	return c
+}
+
+func setupKeyset(ctx context.Context, c genCfg) {
+	var err error
+	if c.keyLedger != "" {
+		usage, err = ledger.Open(c.keyLedger, ledger.Policy{
+			MaxMessages: c.keyMaxMessages,
+			MaxAge:      time.Duration(c.keyMaxAgeDays) * 24 * time.Hour,
+			Refuse:      c.keyLimitAction == "refuse",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keyHandle, err := keys.VerifyKeyset(ctx, c.keyset, c.masterKeyURI, keys.Expect{KeyType: "AES256_GCM", Fingerprint: c.keysetFingerprint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if usage != nil {
+		k, err := keys.FetchKeyset(ctx, c.keyset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := usage.Check(keyHandle.KeysetInfo().GetPrimaryKeyId(), k.Created); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	encrypter, err = aead.New(keyHandle)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func encryptData(data string) string {
+	dataInBytes := []byte(data)
+	encryptionContext := []byte("")
+
+	encryptedData, err := encrypter.Encrypt(dataInBytes, encryptionContext)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auditRun != nil {
+		auditRun.Encrypted(encryptedData)
+	}
+	if usage != nil {
+		if err := usage.Record(encryptedData); err != nil {
+			// Keep the counts of the messages encrypted so far.
+			if err := usage.Save(); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(encryptedData)
+}
+
+func readLayout(c genCfg) (*fixedwidth.Layout, error) {
+	name, parse := c.layout, fixedwidth.ParseLayout
+	if c.copybook != "" {
+		name, parse = c.copybook, fixedwidth.ParseCopybook
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parse(f)
+}
+
+// spaceIn returns the encoding of a space, used to pad short records.
+func spaceIn(enc encoding.Encoding) byte {
+	if enc == nil {
+		return ' '
+	}
+	space, err := enc.NewEncoder().Bytes([]byte(" "))
+	if err != nil || len(space) != 1 {
+		return ' '
+	}
+	return space[0]
+}
+
+func main() {
+	cfg := parseFlags()
+	// The layout is checked before any key is loaded.
+	layout, err := readLayout(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.recordLength == 0 {
+		cfg.recordLength = layout.RecordLength
+	}
+
+	columns := layout.Columns()
+	fieldsToEncrypt := make(map[string]int)
+	for _, val := range strings.Split(cfg.fields, ",") {
+		fieldsToEncrypt[strings.ToLower(strings.ReplaceAll(val, "-", "_"))] = 0
+	}
+	columnsToEncrypt := make(map[int]int)
+	for index, column := range columns {
+		if _, hasKeyInMap := fieldsToEncrypt[strings.ToLower(column)]; hasKeyInMap {
+			columnsToEncrypt[index] = 0
+			fieldsToEncrypt[strings.ToLower(column)]++
+		}
+	}
+	// A misspelled field would otherwise be written in plaintext.
+	for _, val := range strings.Split(cfg.fields, ",") {
+		if fieldsToEncrypt[strings.ToLower(strings.ReplaceAll(val, "-", "_"))] == 0 {
+			log.Fatalf("field %q is not in the layout or copybook, which has: %s", val, strings.Join(columns, ", "))
+		}
+	}
+
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.auditLog != "" {
+		auditRun = audit.Start("fixed-width-encrypter", "encrypt")
+		auditRun.Input.Name, auditRun.Output.Name = cfg.in, cfg.out
+		auditRun.Columns = strings.Split(cfg.fields, ",")
+		auditRun.MasterKeyURI = cfg.masterKeyURI
+	}
+	setupKeyset(ctx, cfg)
+
+	enc, err := charset.Lookup(cfg.inputEncoding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pad := spaceIn(enc)
+	if cfg.recordFormat == "" {
+		cfg.recordFormat = fixedwidth.Lines
+		if pad != ' ' || layout.HasBinary() {
+			cfg.recordFormat = fixedwidth.Fixed
+		}
+	}
+	if strings.EqualFold(cfg.recordFormat, fixedwidth.Lines) && layout.HasBinary() {
+		log.Fatal("packed or binary fields may hold newline bytes, use -record-format fixed or variable for this layout.")
+	}
+
+	in, err := compress.Open(cfg.in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	inReader, err := fixedwidth.NewReader(in, cfg.recordFormat, cfg.recordLength, pad)
+	if err != nil {
+		log.Fatal(err)
+	}
+	decoder := fixedwidth.NewDecoder(layout, enc)
+
+	out, err := compress.Create(cfg.out, cfg.compress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outWriter, err := output.NewWriter(out, cfg.outFormat, columns)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for {
+		record, err := inReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		values, err := decoder.Decode(record)
+		if err != nil {
+			log.Fatalf("record %d: %v", inReader.Record(), err)
+		}
+
+		for colToEncryptIndex := range columnsToEncrypt {
+			values[colToEncryptIndex] = encryptData(values[colToEncryptIndex])
+		}
+
+		if err := outWriter.Write(values); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := outWriter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	// Closing the output flushes any pending compressed data.
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if usage != nil {
+		if err := usage.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if auditRun != nil {
+		if err := audit.Append(cfg.auditLog, auditRun); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
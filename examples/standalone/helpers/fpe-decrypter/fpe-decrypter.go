@@ -0,0 +1,563 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// fpe-decrypter decrypts the columns of a csv or json file that were encrypted
+// with -fpe-fields, reverses the dates shifted with -date-shift-fields, and can
+// verify that they round-trip to the original file.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"encrypter-common/audit"
+	"encrypter-common/compress"
+	"encrypter-common/dateshift"
+	"encrypter-common/fpe"
+	"encrypter-common/keys"
+)
+
+// decrypter config
+type decryptCfg struct {
+	in           string
+	out          string
+	verify       string
+	format       string
+	fpeFields    string
+	fpeKeyset    string
+	shiftFields  string
+	shiftSubject string
+	shiftKeyset  string
+	shiftMax     string
+	shiftLayout  string
+	shiftOutput  string
+	masterKeyURI string
+	credentials  keys.Credentials
+	auditLog     string
+	compress     string
+	delimiter    string
+	comment      string
+	lazyQuotes   bool
+	noHeader     bool
+}
+
+func parseFlags() decryptCfg {
+	var c decryptCfg
+	flag.StringVar(&c.in, "in", "", "Filename to read encrypted csv or newline-delimited json data.")
+	flag.StringVar(&c.out, "out", "", "Filename to write the data with the format-preserving encrypted columns decrypted. Optional with -verify.")
+	flag.StringVar(&c.verify, "verify", "", "Filename of the original plaintext data. The decrypted columns are compared with it record by record, and the run fails on any mismatch.")
+	flag.StringVar(&c.format, "format", "", "Format of the input: csv or newline-delimited json. Inferred from the input filename extension when not set.")
+	flag.StringVar(&c.fpeFields, "fpe-fields", "", "The fpe-fields flag given to the encrypter. i.e. \"Card_Number:ff1:digits:column\"")
+	flag.StringVar(&c.fpeKeyset, "fpe-keyset", "", "The PRF keyset filename or source given to the encrypter with -fpe-keyset.")
+	flag.StringVar(&c.shiftFields, "date-shift-fields", "", "The date-shift-fields flag given to the encrypter. i.e. \"Issue_Date,Expiry_Date\"")
+	flag.StringVar(&c.shiftSubject, "date-shift-subject", "", "The date-shift-subject flag given to the encrypter. Its values must be plaintext once the format-preserving encrypted columns are decrypted.")
+	flag.StringVar(&c.shiftKeyset, "date-shift-keyset", "", "The PRF keyset filename or source given to the encrypter with -date-shift-keyset.")
+	flag.StringVar(&c.shiftMax, "date-shift-max", "12m", "The date-shift-max flag given to the encrypter.")
+	flag.StringVar(&c.shiftLayout, "date-shift-layout", "01/2006", "The date-shift-layout flag given to the encrypter.")
+	flag.StringVar(&c.shiftOutput, "date-shift-output-layout", "", "The date-shift-output-layout flag given to the encrypter.")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set.")
+	flag.StringVar(&c.delimiter, "delimiter", ",", "The delimiter flag given to the encrypter. Field delimiter of the input csv, also used in the output. A single character, or \"tab\".")
+	flag.StringVar(&c.comment, "comment", "", "The comment flag given to the encrypter. Lines beginning with this character are skipped and not written to the output. Disabled when empty.")
+	flag.BoolVar(&c.lazyQuotes, "lazy-quotes", false, "The lazy-quotes flag given to the encrypter.")
+	flag.BoolVar(&c.noHeader, "no-header", false, "The no-header flag given to the encrypter. The input csv has no header row, so fields are selected by 1-based column number.")
+	flag.Parse()
+	if c.fpeFields == "" && c.shiftFields == "" {
+		log.Fatal("fpe-fields or date-shift-fields flag is missing.")
+	}
+	if c.fpeFields != "" && c.fpeKeyset == "" {
+		log.Fatal("PRF keyset filename is missing.")
+	}
+	if c.shiftFields != "" && (c.shiftSubject == "" || c.shiftKeyset == "") {
+		log.Fatal("date-shift-subject or date-shift-keyset flag is missing.")
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	if c.in == "" {
+		log.Fatal("Input filename is missing.")
+	}
+	if c.out == "" && c.verify == "" {
+		log.Fatal("Output filename is missing. Set -out, -verify or both.")
+	}
+	if c.format == "" {
+		c.format = formatFromFilename(c.in)
+	}
+	return c
+}
+
+// formatFromFilename infers the input format, ignoring compression extensions.
+func formatFromFilename(name string) string {
+	if compress.FromFilename(name) != compress.None {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".jsonl", ".ndjson":
+		return "json"
+	default:
+		return "csv"
+	}
+}
+
+// record is a csv row or json object, with its field names in input order.
+// Values are strings for csv and json.RawMessage for json, so fields that
+// are not decrypted are written back as they were read.
+type record struct {
+	names  []string
+	values map[string]any
+}
+
+// String returns the value of a field, and false when it is missing or not a string.
+func (r *record) String(name string) (string, bool) {
+	switch v := r.values[name].(type) {
+	case string:
+		return v, true
+	case json.RawMessage:
+		var s string
+		if len(v) > 0 && v[0] == '"' && json.Unmarshal(v, &s) == nil {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of a field that was read.
+func (r *record) Set(name, value string) {
+	if _, ok := r.values[name].(json.RawMessage); ok {
+		// Marshalling a string cannot fail.
+		b, _ := json.Marshal(value)
+		r.values[name] = json.RawMessage(b)
+		return
+	}
+	r.values[name] = value
+}
+
+// recordReader reads records.
+type recordReader interface {
+	Read() (*record, error)
+}
+
+// recordWriter writes records read by a recordReader.
+type recordWriter interface {
+	Write(*record) error
+	Close() error
+}
+
+// parseDialectRune parses a single character flag value, accepting "tab" and "\t" for a tab.
+func parseDialectRune(name, value string) (rune, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if r == utf8.RuneError || size != len(value) {
+		return 0, fmt.Errorf("%s must be a single character, got %q", name, value)
+	}
+	return r, nil
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+// newCsvReader returns a csv reader configured with the dialect flags, which
+// reads the header unless the input has none.
+func newCsvReader(in io.Reader, c decryptCfg) (*csvReader, error) {
+	delimiter, err := parseDialectRune("delimiter", c.delimiter)
+	if err != nil {
+		return nil, err
+	}
+	if delimiter == 0 {
+		return nil, errors.New("delimiter must not be empty")
+	}
+	comment, err := parseDialectRune("comment", c.comment)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(in)
+	r.Comma = delimiter
+	r.Comment = comment
+	r.LazyQuotes = c.lazyQuotes
+	if c.noHeader {
+		return &csvReader{r: r}, nil
+	}
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return &csvReader{r: r, header: header}, nil
+}
+
+func (c *csvReader) Read() (*record, error) {
+	values, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{names: c.header, values: make(map[string]any, len(values))}
+	if c.header == nil {
+		// Without a header, fields are named by their 1-based column number.
+		rec.names = make([]string, len(values))
+		for i := range values {
+			rec.names[i] = strconv.Itoa(i + 1)
+		}
+	}
+	for i, value := range values {
+		if i < len(rec.names) {
+			rec.values[rec.names[i]] = value
+		}
+	}
+	return rec, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(rec *record) error {
+	values := make([]string, len(rec.names))
+	for i, name := range rec.names {
+		values[i], _ = rec.String(name)
+	}
+	return c.w.Write(values)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonReader struct {
+	d *json.Decoder
+}
+
+// Read decodes the next object, keeping the order of its fields and the
+// encoding of their values.
+func (j jsonReader) Read() (*record, error) {
+	var object json.RawMessage
+	if err := j.d.Decode(&object); err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(object))
+	if token, err := d.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('{') {
+		return nil, errors.New("records must be json objects")
+	}
+	rec := &record{values: make(map[string]any)}
+	for d.More() {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		// Object keys are always strings.
+		name := token.(string)
+		var value json.RawMessage
+		if err := d.Decode(&value); err != nil {
+			return nil, err
+		}
+		if _, ok := rec.values[name]; !ok {
+			rec.names = append(rec.names, name)
+		}
+		rec.values[name] = value
+	}
+	return rec, nil
+}
+
+type jsonWriter struct {
+	w io.Writer
+}
+
+func (j jsonWriter) Write(rec *record) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, name := range rec.names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(rec.values[name])
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	_, err := j.w.Write(b.Bytes())
+	return err
+}
+
+func (j jsonWriter) Close() error {
+	return nil
+}
+
+func openRecords(name string, c decryptCfg) (recordReader, []string, io.Closer, error) {
+	in, err := compress.Open(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	switch c.format {
+	case "csv":
+		r, err := newCsvReader(in, c)
+		if err != nil {
+			in.Close()
+			return nil, nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return r, r.header, in, nil
+	case "json":
+		return jsonReader{d: json.NewDecoder(in)}, nil, in, nil
+	default:
+		in.Close()
+		return nil, nil, nil, fmt.Errorf("unknown format %q, use csv or json", c.format)
+	}
+}
+
+// decryptRecord decrypts the transformed fields of a record in place, then
+// reverses the date shifts with the decrypted subject. Fields that are
+// missing or not strings, like json nulls, are left as they are.
+func decryptRecord(transforms []*fpe.Transform, shifter *dateshift.Shifter, shiftFields []string, subject string, rec *record) error {
+	lookup := func(field string) string {
+		value, _ := rec.String(field)
+		return value
+	}
+	decrypted := make([]string, len(transforms))
+	for i, t := range transforms {
+		value, ok := rec.String(t.Column)
+		if !ok {
+			continue
+		}
+		var err error
+		if decrypted[i], err = t.Decrypt(value, lookup); err != nil {
+			return err
+		}
+	}
+	for i, t := range transforms {
+		if _, ok := rec.String(t.Column); ok {
+			rec.Set(t.Column, decrypted[i])
+		}
+	}
+	for _, field := range shiftFields {
+		value, ok := rec.String(field)
+		if !ok {
+			continue
+		}
+		unshifted, err := shifter.Unshift(value, lookup(subject))
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		rec.Set(field, unshifted)
+	}
+	return nil
+}
+
+// sameField reports whether a field has the same string value, or is missing or
+// not a string in both records.
+func sameField(a, b *record, name string) bool {
+	va, oka := a.String(name)
+	vb, okb := b.String(name)
+	return oka == okb && va == vb
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+
+	var run *audit.Run
+	if cfg.auditLog != "" {
+		run = audit.Start("fpe-decrypter", "decrypt")
+		run.Input.Name, run.Output.Name = cfg.in, cfg.out
+		run.MasterKeyURI = cfg.masterKeyURI
+	}
+
+	var transforms []*fpe.Transform
+	if cfg.fpeFields != "" {
+		fpeKeys, err := fpe.Load(ctx, cfg.fpeKeyset, cfg.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if transforms, err = fpeKeys.Transforms(cfg.fpeFields); err != nil {
+			log.Fatal(err)
+		}
+		if run != nil {
+			run.AddKey(fpeKeys.KeyID())
+			for _, t := range transforms {
+				run.Columns = append(run.Columns, t.Column)
+			}
+		}
+	}
+
+	var shifter *dateshift.Shifter
+	var shiftFields []string
+	if cfg.shiftFields != "" {
+		var err error
+		shifter, err = dateshift.Load(ctx, cfg.shiftKeyset, cfg.masterKeyURI, cfg.shiftMax, cfg.shiftLayout, cfg.shiftOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		shiftFields = strings.Split(cfg.shiftFields, ",")
+		if run != nil {
+			run.AddKey(shifter.KeyID())
+			run.Columns = append(run.Columns, shiftFields...)
+		}
+	}
+
+	records, header, in, err := openRecords(cfg.in, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	var originals recordReader
+	if cfg.verify != "" {
+		var original io.Closer
+		originals, _, original, err = openRecords(cfg.verify, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer original.Close()
+	}
+
+	var out io.WriteCloser
+	var outWriter recordWriter
+	if cfg.out != "" {
+		out, err = compress.Create(cfg.out, cfg.compress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cfg.format == "csv" {
+			w := csv.NewWriter(out)
+			w.Comma = records.(*csvReader).r.Comma
+			if header != nil {
+				if err := w.Write(header); err != nil {
+					log.Fatal(err)
+				}
+			}
+			outWriter = &csvWriter{w: w}
+		} else {
+			outWriter = jsonWriter{w: out}
+		}
+	}
+
+	count, mismatches, fewer := 0, 0, false
+	for {
+		values, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		count++
+
+		if err := decryptRecord(transforms, shifter, shiftFields, cfg.shiftSubject, values); err != nil {
+			log.Fatalf("record %d: %v", count, err)
+		}
+
+		if originals != nil {
+			original, err := originals.Read()
+			if err == io.EOF {
+				fewer = true
+				break
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, t := range transforms {
+				if !sameField(values, original, t.Column) {
+					mismatches++
+					log.Printf("record %d: %s does not match the original value", count, t.Column)
+				}
+			}
+			for _, field := range shiftFields {
+				if !sameField(values, original, field) {
+					mismatches++
+					log.Printf("record %d: %s does not match the original value", count, field)
+				}
+			}
+		}
+
+		if outWriter != nil {
+			if err := outWriter.Write(values); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if outWriter != nil {
+		if err := outWriter.Close(); err != nil {
+			log.Fatal(err)
+		}
+		// Closing the output flushes any pending compressed data.
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if originals != nil {
+		// A failed verification is recorded before exiting.
+		if fewer {
+			appendAudit(cfg, run, audit.StatusVerifyFailed)
+			log.Fatalf("record %d: %s has fewer records than %s", count, cfg.verify, cfg.in)
+		}
+		if _, err := originals.Read(); err != io.EOF {
+			appendAudit(cfg, run, audit.StatusVerifyFailed)
+			log.Fatalf("%s has more records than %s", cfg.verify, cfg.in)
+		}
+		if mismatches > 0 {
+			appendAudit(cfg, run, audit.StatusVerifyFailed)
+			log.Fatalf("%d values in %d records do not match the original", mismatches, count)
+		}
+	}
+	appendAudit(cfg, run, audit.StatusOK)
+	if originals != nil {
+		log.Printf("%d records verified, all format-preserving encrypted and date shifted values round-trip", count)
+	}
+}
+
+// appendAudit appends the record of the run with its status to the audit
+// log, when there is one.
+func appendAudit(c decryptCfg, run *audit.Run, status string) {
+	if run == nil {
+		return
+	}
+	run.Status = status
+	if err := audit.Append(c.auditLog, run); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright 2023-2025 Google LLC
+// Copyright 2023-2026 Google LLC
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
 // you may not use this file except in compliance with the License.
@@ -19,29 +19,80 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/tink-crypto/tink-go-gcpkms/v2/integration/gcpkms"
 	"github.com/tink-crypto/tink-go/v2/aead"
-	"github.com/tink-crypto/tink-go/v2/core/registry"
-	"github.com/tink-crypto/tink-go/v2/keyset"
 	"github.com/tink-crypto/tink-go/v2/tink"
+
+	"encrypter-common/audit"
+	"encrypter-common/blindindex"
+	"encrypter-common/compress"
+	"encrypter-common/dateshift"
+	"encrypter-common/dek"
+	"encrypter-common/fpe"
+	"encrypter-common/generalize"
+	"encrypter-common/keys"
+	"encrypter-common/ledger"
+	"encrypter-common/padding"
+	"encrypter-common/pii"
+	"encrypter-common/tenant"
 )
 
 var (
 	encrypter tink.HybridEncrypt
+	indexer   *blindindex.Indexer
+	fpeKeys   *fpe.KeySet
+	shifter   *dateshift.Shifter
+	tenants   *tenant.Store
+	rotator   *dek.Rotator
+	usage     *ledger.Ledger
+	auditRun  *audit.Run
 )
 
 // generator config
 type genCfg struct {
-	in           string
-	out          string
-	fields       string
-	keyset       string
-	masterKeyURI string
+	in                string
+	out               string
+	fields            string
+	keyset            string
+	keysetFingerprint string
+	masterKeyURI      string
+	credentials       keys.Credentials
+	auditLog          string
+	compress          string
+	blindIndexFields  string
+	blindIndexKeyset  string
+	fpeFields         string
+	fpeKeyset         string
+	padFields         string
+	dekPerRun         bool
+	keyLedger         string
+	keyMaxMessages    uint64
+	keyMaxAgeDays     int
+	keyLimitAction    string
+	dekBatchSize      int
+	dekManifestDir    string
+	tenantField       string
+	tenantKeysetDir   string
+	generalize        string
+	dateShiftFields   string
+	dateShiftSubject  string
+	dateShiftKeyset   string
+	dateShiftMax      string
+	dateShiftLayout   string
+	dateShiftOutput   string
+	kAnonColumns      string
+	kAnonReport       string
+	kAnonThreshold    int
+	piiAllow          string
+	piiMinLikelihood  string
+	piiSampleSize     int
 }
 
 func parseFlags() genCfg {
@@ -49,12 +100,64 @@ func parseFlags() genCfg {
 	flag.StringVar(&c.in, "in", "", "Filename to read json data.")
 	flag.StringVar(&c.out, "out", "", "Filename to write encrypted json data.")
 	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of JSON field names that need to be encrypted. i.e. \"Card Type Full Name,Issuing Bank\"")
-	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data.")
-	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY'.")
+	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data, or a keyset source: file://<path>, env://<variable> holding the keyset JSON or its base64 encoding, gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>] or vault-kv://<mount>/<path>[?version=<version>&field=<field>], i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset/versions/3\". Set KEYSET_CACHE_DIR to cache keysets fetched from Secret Manager or Vault.")
+	flag.StringVar(&c.keysetFingerprint, "keyset-fingerprint", "", "Fingerprint the keyset is pinned to, as logged by earlier runs or printed by verify-keyset. The run stops before encrypting any record if the keyset does not match, i.e. it was swapped. i.e. \"sha256:3b4c...\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set. Compressed input is always detected automatically.")
+	flag.StringVar(&c.blindIndexFields, "blind-index-fields", "", "Comma-separated list of JSON field names to write a blind index for, in an extra <name>_bidx field. The blind index is a keyed PRF of the normalized plaintext, so equality lookups work without decrypting. i.e. \"Card_Number\"")
+	flag.StringVar(&c.blindIndexKeyset, "blind-index-keyset", "", "PRF keyset filename used to compute blind indexes, i.e. created with the HMAC_SHA256_PRF key template. Wrapped with the master key. A file or keyset source like -keyset.")
+	flag.StringVar(&c.fpeFields, "fpe-fields", "", "Comma-separated list of JSON field names to encrypt with format-preserving encryption instead of AEAD, so values keep their length and characters. Each entry is column[:algorithm[:alphabet[:tweak]]]: algorithm ff1 (default) or ff3-1, alphabet digits (default), hex, upper-hex, lower, upper, alnum-lower, alnum-upper, alnum or chars=<characters>, tweak none (default), column, const=<text> or value=<column> to use the plaintext of an unencrypted column. Characters outside the alphabet are kept. Values must have at least a million possible values, as NIST SP 800-38G requires, i.e. 6 digits, so PINs and other values shorter than 6 digits are not supported: encrypt them with AEAD in -fields and hide their length with -pad-fields, i.e. Card_PIN:8. i.e. \"Card_Number:ff1:digits:column\"")
+	flag.StringVar(&c.fpeKeyset, "fpe-keyset", "", "PRF keyset filename the format-preserving encryption keys are derived from, i.e. created with the HMAC_SHA256_PRF key template. Wrapped with the master key. A file or keyset source like -keyset.")
+	flag.StringVar(&c.keyLedger, "key-usage-ledger", "", "Filename of a ledger counting the messages encrypted by each key ID, kept across runs. Keys are checked against -key-max-messages and -key-max-age-days. Disabled when empty.")
+	flag.Uint64Var(&c.keyMaxMessages, "key-max-messages", ledger.DefaultMaxMessages, "Number of messages a key may encrypt before it must be rotated, 0 for no limit. The default is the AES-GCM bound for random nonces.")
+	flag.IntVar(&c.keyMaxAgeDays, "key-max-age-days", 365, "Age in days past which a key must be rotated, 0 for no limit. A key is dated by the modification time of its keyset file when first recorded in the ledger.")
+	flag.StringVar(&c.keyLimitAction, "key-limit-action", "warn", "What to do when a key exceeds -key-max-messages or -key-max-age-days: warn, or refuse to encrypt.")
+	flag.BoolVar(&c.dekPerRun, "dek-per-run", false, "Encrypt with a new data keyset generated for this run instead of -keyset. The keyset is wrapped with the master key and written to the manifest <out>.dek.json, from which the keyset-chain helper emits BigQuery decrypt expressions. -keyset is then not used.")
+	flag.IntVar(&c.dekBatchSize, "dek-batch-size", 0, "Generate a new data keyset every this many records, recorded as batches in the manifest. Implies -dek-per-run.")
+	flag.StringVar(&c.dekManifestDir, "dek-manifest-dir", "", "Directory of the manifests of earlier runs, whose key IDs the new data keysets must not reuse so keyset-chain can tell all their ciphertexts apart. Defaults to the directory of -out.")
+	flag.StringVar(&c.tenantField, "tenant-field", "", "Unencrypted JSON field name of the tenant or customer of each record. The fields of a record are encrypted with a keyset of its tenant from -tenant-keyset-dir instead of -keyset, so the data of a tenant can be crypto-shredded with the shred helper. i.e. \"Customer_ID\"")
+	flag.StringVar(&c.tenantKeysetDir, "tenant-keyset-dir", "", "Directory of the per-tenant keysets, wrapped with the master key. The keyset of a new tenant is created on first use. Records of shredded tenants are rejected. Print the BigQuery expression that decrypts them with keyset-chain -tenant-keyset-dir.")
+	flag.StringVar(&c.padFields, "pad-fields", "", "Comma-separated list of encrypted JSON field names to pad before encryption, so the ciphertext length does not reveal the plaintext length. Each entry is column:bucket[:bucket...] with bucket sizes in bytes. Values are padded to the smallest bucket that fits, or to a multiple of the largest one. The decrypted view of the example strips the padding of the columns of its pad_fields variable. i.e. \"Card_Holders_Name:32:64,Card_PIN:8\"")
+	flag.StringVar(&c.generalize, "generalize", "", "Comma-separated list of column:transform to generalize unencrypted JSON field names. These transforms are not reversible. transform is year[=layout] or month[=layout] for dates, range=<width> for numbers, truncate=<n> or mask=<n> to keep the first n characters, or suppress. i.e. \"Issue_Date:year,Credit_Limit:range=10000,Zip:mask=3\"")
+	flag.StringVar(&c.dateShiftFields, "date-shift-fields", "", "Comma-separated list of unencrypted date JSON field names to shift by an offset derived from the value of -date-shift-subject, so intervals between the dates of a subject are kept without revealing them. i.e. \"Issue_Date,Expiry_Date\"")
+	flag.StringVar(&c.dateShiftSubject, "date-shift-subject", "", "JSON field name of the subject, i.e. the cardholder, whose dates are shifted by the same offset. Reversing the shift needs the subject plaintext, so it should be unencrypted or format-preserving encrypted. i.e. \"Card_Number\"")
+	flag.StringVar(&c.dateShiftKeyset, "date-shift-keyset", "", "PRF keyset filename the date offsets are derived from, i.e. created with the HMAC_SHA256_PRF key template. Wrapped with the master key. A file or keyset source like -keyset. Shifted dates can only be reversed with this keyset.")
+	flag.StringVar(&c.dateShiftMax, "date-shift-max", "12m", "Largest date offset, as a number of days (d) or months (m). Offsets are in [-max, max] and never 0. Shift by months for layouts without a day. i.e. \"365d\" or \"12m\"")
+	flag.StringVar(&c.dateShiftLayout, "date-shift-layout", "01/2006", "Layout of the dates to shift, in Go reference time format. i.e. \"01/2006\" for MM/YYYY or \"2006-01-02\"")
+	flag.StringVar(&c.dateShiftOutput, "date-shift-output-layout", "", "Layout of the shifted dates, in Go reference time format. Defaults to -date-shift-layout.")
+	flag.StringVar(&c.kAnonColumns, "k-anonymity-columns", "", "Comma-separated list of quasi-identifier JSON field names. The k-anonymity of the output over these columns is logged and written to -k-anonymity-report. i.e. \"Issue_Date,Credit_Limit\"")
+	flag.StringVar(&c.kAnonReport, "k-anonymity-report", "", "Filename to write the k-anonymity report as JSON. Not written when empty.")
+	flag.IntVar(&c.kAnonThreshold, "k-anonymity-threshold", 5, "Equivalence classes with fewer records than this are counted as below the threshold in the k-anonymity report.")
+	flag.StringVar(&c.piiAllow, "pii-allow", "", "Comma-separated list of unencrypted columns allowed to hold PII, for known false positives. An entry is a column name, or column:INFO_TYPE to allow a single info type. i.e. \"Issuing_Bank,Notes:PERSON_NAME\"")
+	flag.StringVar(&c.piiMinLikelihood, "pii-min-likelihood", "LIKELY", "The run is refused when an unencrypted column holds PII with this likelihood or higher: POSSIBLE, LIKELY or VERY_LIKELY.")
+	flag.IntVar(&c.piiSampleSize, "pii-sample-size", 10000, "Number of records checked for PII in unencrypted columns. 0 checks all records.")
 	flag.Parse()
 	if c.fields == "" {
 		log.Fatal("fields flag is missing. Please set fields flag that is a comma-separated list of JSON field names that need to be encrypted. i.e. -fields \"Card Type Full Name,Issuing Bank\"")
 	}
+	if c.blindIndexFields != "" && c.blindIndexKeyset == "" {
+		log.Fatal("blind-index-keyset flag is missing. A PRF keyset is required to compute blind indexes.")
+	}
+	if c.fpeFields != "" && c.fpeKeyset == "" {
+		log.Fatal("fpe-keyset flag is missing. A PRF keyset is required for format-preserving encryption.")
+	}
+	if c.dateShiftFields != "" && (c.dateShiftSubject == "" || c.dateShiftKeyset == "") {
+		log.Fatal("date-shift-subject or date-shift-keyset flag is missing. Dates are shifted per subject with a PRF keyset.")
+	}
+	if c.keyLimitAction != "warn" && c.keyLimitAction != "refuse" {
+		log.Fatalf("invalid key-limit-action %q, use warn or refuse.", c.keyLimitAction)
+	}
+	if c.dekBatchSize > 0 {
+		c.dekPerRun = true
+	}
+	if c.dekPerRun && c.tenantField != "" {
+		log.Fatal("dek-per-run and tenant-field flags can not be used together.")
+	}
+	if c.tenantField != "" && c.tenantKeysetDir == "" {
+		log.Fatal("tenant-keyset-dir flag is missing. Per-tenant keysets are stored in a directory.")
+	}
 	if c.keyset == "" {
 		log.Fatal("Keyset filename to be used to encrypt the data is missing.")
 	}
@@ -70,76 +173,286 @@ func parseFlags() genCfg {
 	return c
 }
 
-func loadMasterKeyFromKMS(ctx context.Context, c genCfg) (tink.AEAD, error) {
-	// Fetch the master key from a KMS.
-	gcpClient, err := gcpkms.NewClientWithOptions(ctx, c.masterKeyURI)
+// fpeTransforms parses the fpe-fields flag. Fields and their tweak fields
+// must not be encrypted with AEAD.
+func fpeTransforms(spec string, encrypted map[string]int) ([]*fpe.Transform, error) {
+	transforms, err := fpeKeys.Transforms(spec)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	registry.RegisterKMSClient(gcpClient)
-	masterKey, err := gcpClient.GetAEAD(c.masterKeyURI)
-	if err != nil {
-		log.Fatal(err)
+	transformed := make(map[string]int)
+	for _, t := range transforms {
+		if _, ok := encrypted[t.Column]; ok {
+			return nil, fmt.Errorf("field %q is in both fields and fpe-fields", t.Column)
+		}
+		transformed[t.Column] = 0
+	}
+	for _, t := range transforms {
+		_, isEncrypted := encrypted[t.TweakColumn]
+		_, isTransformed := transformed[t.TweakColumn]
+		if t.TweakColumn != "" && (isEncrypted || isTransformed) {
+			return nil, fmt.Errorf("tweak field %q of %q must not be encrypted, it is needed to decrypt", t.TweakColumn, t.Column)
+		}
 	}
+	return transforms, nil
+}
 
-	return masterKey, err
+// writeKAnonymityReport logs the k-anonymity achieved and writes the report
+// to filename, if set.
+func writeKAnonymityReport(r generalize.Report, filename string) error {
+	log.Printf("k-anonymity over %s: k=%d, %d equivalence classes, %d of %d records in classes below %d",
+		strings.Join(r.QuasiIdentifiers, ","), r.K, r.EquivalenceClasses, r.RecordsBelowThreshold, r.Records, r.KThreshold)
+	if filename == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(b, '\n'), 0644)
 }
 
 func setupKeyset(ctx context.Context, c genCfg) {
 	var err error
+	if c.keyLedger != "" {
+		usage, err = ledger.Open(c.keyLedger, ledger.Policy{
+			MaxMessages: c.keyMaxMessages,
+			MaxAge:      time.Duration(c.keyMaxAgeDays) * 24 * time.Hour,
+			Refuse:      c.keyLimitAction == "refuse",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	f, err := os.Open(c.keyset)
-	if err != nil {
-		log.Fatal(err)
+	if !c.dekPerRun {
+		keyHandle, err := keys.VerifyKeyset(ctx, c.keyset, c.masterKeyURI, keys.Expect{KeyType: "AES256_GCM", Fingerprint: c.keysetFingerprint})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if usage != nil {
+			k, err := keys.FetchKeyset(ctx, c.keyset)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := usage.Check(keyHandle.KeysetInfo().GetPrimaryKeyId(), k.Created); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		encrypter, err = aead.New(keyHandle)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.dekPerRun {
+		manifestDir := c.dekManifestDir
+		if manifestDir == "" {
+			manifestDir = filepath.Dir(c.out)
+		}
+		rotator, err = dek.NewRotator(ctx, c.masterKeyURI, c.dekBatchSize, c.out+dek.ManifestSuffix, manifestDir)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	defer f.Close()
 
-	masterKey, errKey := loadMasterKeyFromKMS(ctx, c)
-	if errKey != nil {
-		log.Fatal(errKey)
+	if c.blindIndexFields != "" {
+		indexer, err = blindindex.Load(ctx, c.blindIndexKeyset, c.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.fpeFields != "" {
+		fpeKeys, err = fpe.Load(ctx, c.fpeKeyset, c.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	keyReader := keyset.NewJSONReader(f)
+	if c.tenantField != "" {
+		tenants, err = tenant.Open(ctx, c.tenantKeysetDir, c.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	keyHandle, err := keyset.Read(keyReader, masterKey)
-	if err != nil {
-		log.Fatal(err)
+	if c.dateShiftFields != "" {
+		shifter, err = dateshift.Load(ctx, c.dateShiftKeyset, c.masterKeyURI, c.dateShiftMax, c.dateShiftLayout, c.dateShiftOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
+}
 
-	encrypter, err = aead.New(keyHandle)
+func blindIndex(data string) string {
+	index, err := indexer.Compute(data)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return index
 }
 
-func encryptData(data string) string {
+func encryptData(e tink.HybridEncrypt, data string) string {
 	dataInBytes := []byte(data)
 	encryptionContext := []byte("")
 
-	encryptedData, err := encrypter.Encrypt(dataInBytes, encryptionContext)
+	encryptedData, err := e.Encrypt(dataInBytes, encryptionContext)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if auditRun != nil {
+		auditRun.Encrypted(encryptedData)
+	}
+	if usage != nil {
+		if err := usage.Record(encryptedData); err != nil {
+			// Keep the counts of the messages encrypted so far.
+			if err := usage.Save(); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+	}
 
 	return base64.StdEncoding.EncodeToString(encryptedData)
 }
 
+func newGuard(c genCfg) (*pii.Guard, error) {
+	minLikelihood, err := pii.ParseLikelihood(c.piiMinLikelihood)
+	if err != nil {
+		return nil, err
+	}
+	return pii.NewGuard(minLikelihood, c.piiSampleSize, strings.Split(c.piiAllow, ",")), nil
+}
+
+// appendAudit appends the record of the run with its status to the audit log.
+func appendAudit(c genCfg, status string) {
+	if auditRun == nil {
+		return
+	}
+	auditRun.Status = status
+	if err := audit.Append(c.auditLog, auditRun); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// refuseOutput removes the partially written output, with its data keyset
+// manifest, records the refusal in the audit log and exits with the PII
+// report.
+func refuseOutput(out io.Closer, c genCfg, err error) {
+	out.Close()
+	os.Remove(c.out)
+	if c.dekPerRun {
+		// The manifest would describe an output that does not exist.
+		os.Remove(c.out + dek.ManifestSuffix)
+	}
+	appendAudit(c, audit.StatusPIIRefused)
+	log.Fatalf("%v\nRefusing to write %s. Encrypt these columns with -fields, or allow known false positives with -pii-allow.", err, c.out)
+}
+
 func main() {
 	cfg := parseFlags()
 	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.auditLog != "" {
+		auditRun = audit.Start("json-encrypter", "encrypt")
+		auditRun.Input.Name, auditRun.Output.Name = cfg.in, cfg.out
+		auditRun.Columns = strings.Split(cfg.fields, ",")
+		auditRun.MasterKeyURI = cfg.masterKeyURI
+	}
 	setupKeyset(ctx, cfg)
 
 	headersToEncryptList := strings.Split(cfg.fields, ",")
+	headersToEncryptMap := make(map[string]int)
+	for _, val := range headersToEncryptList {
+		headersToEncryptMap[val] = 0
+	}
+
+	guard, err := newGuard(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var kAnon *generalize.KAnonymity
+	var kAnonColumns []string
+	if cfg.kAnonColumns != "" {
+		kAnonColumns = strings.Split(cfg.kAnonColumns, ",")
+		kAnon = generalize.NewKAnonymity(kAnonColumns)
+	}
+
+	paddedFields := make(map[string]padding.Field)
+	if cfg.padFields != "" {
+		padFields, err := padding.ParseFields(cfg.padFields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, f := range padFields {
+			if _, ok := headersToEncryptMap[f.Column]; !ok {
+				log.Fatalf("pad field %q must also be in fields", f.Column)
+			}
+			paddedFields[f.Column] = f
+		}
+	}
+
+	var transforms []*fpe.Transform
+	if cfg.fpeFields != "" {
+		transforms, err = fpeTransforms(cfg.fpeFields, headersToEncryptMap)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	transformed := make(map[string]int)
+	for _, t := range transforms {
+		transformed[t.Column] = 0
+	}
+
+	if cfg.tenantField != "" {
+		_, encrypted := headersToEncryptMap[cfg.tenantField]
+		_, isTransformed := transformed[cfg.tenantField]
+		if encrypted || isTransformed {
+			log.Fatalf("tenant field %q must not be encrypted", cfg.tenantField)
+		}
+	}
+
+	var shiftFields []string
+	if cfg.dateShiftFields != "" {
+		shiftFields = strings.Split(cfg.dateShiftFields, ",")
+		for _, field := range shiftFields {
+			_, encrypted := headersToEncryptMap[field]
+			_, isTransformed := transformed[field]
+			if encrypted || isTransformed {
+				log.Fatalf("date shift field %q must not be encrypted", field)
+			}
+			transformed[field] = 0
+		}
+	}
 
-	out, err := os.OpenFile(cfg.out, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	var generalizeTransforms []generalize.Transform
+	if cfg.generalize != "" {
+		generalizeTransforms, err = generalize.ParseTransforms(cfg.generalize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, t := range generalizeTransforms {
+			_, encrypted := headersToEncryptMap[t.Column]
+			_, isTransformed := transformed[t.Column]
+			if encrypted || isTransformed {
+				log.Fatalf("generalize field %q must not be encrypted or date shifted", t.Column)
+			}
+		}
+	}
+
+	out, err := compress.Create(cfg.out, cfg.compress)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer out.Close()
 
-	in, err := os.Open(cfg.in)
+	in, err := compress.Open(cfg.in)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 	defer in.Close()
 
@@ -153,8 +466,83 @@ func main() {
 			log.Fatal(err)
 		}
 
+		if cfg.blindIndexFields != "" {
+			for _, colToIndex := range strings.Split(cfg.blindIndexFields, ",") {
+				jsonLine[colToIndex+blindindex.Suffix] = blindIndex(jsonLine[colToIndex])
+			}
+		}
+
+		lookup := func(field string) string {
+			return jsonLine[field]
+		}
+		fpeValues := make([]string, len(transforms))
+		for i, t := range transforms {
+			if fpeValues[i], err = t.Encrypt(jsonLine[t.Column], lookup); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		shifted := make([]string, len(shiftFields))
+		for i, field := range shiftFields {
+			if shifted[i], err = shifter.Shift(jsonLine[field], jsonLine[cfg.dateShiftSubject]); err != nil {
+				log.Fatalf("%s: %v", field, err)
+			}
+		}
+
+		recordEncrypter := encrypter
+		if tenants != nil {
+			if recordEncrypter, err = tenants.AEAD(jsonLine[cfg.tenantField]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if rotator != nil {
+			if recordEncrypter, err = rotator.Next(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
 		for _, colToEncrypt := range headersToEncryptList {
-			jsonLine[colToEncrypt] = encryptData(jsonLine[colToEncrypt])
+			value := jsonLine[colToEncrypt]
+			if f, ok := paddedFields[colToEncrypt]; ok {
+				value = f.Pad(value)
+			}
+			jsonLine[colToEncrypt] = encryptData(recordEncrypter, value)
+		}
+		for i, t := range transforms {
+			if _, ok := jsonLine[t.Column]; ok {
+				jsonLine[t.Column] = fpeValues[i]
+			}
+		}
+		for i, field := range shiftFields {
+			if _, ok := jsonLine[field]; ok {
+				jsonLine[field] = shifted[i]
+			}
+		}
+		for _, t := range generalizeTransforms {
+			if value, ok := jsonLine[t.Column]; ok {
+				if jsonLine[t.Column], err = t.Apply(value); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+
+		for name, value := range jsonLine {
+			_, encrypted := headersToEncryptMap[name]
+			_, isTransformed := transformed[name]
+			if !encrypted && !isTransformed {
+				guard.Add(name, value)
+			}
+		}
+		if err := guard.EndRecord(); err != nil {
+			refuseOutput(out, cfg, err)
+		}
+
+		if kAnon != nil {
+			values := make([]string, len(kAnonColumns))
+			for i, name := range kAnonColumns {
+				values[i] = jsonLine[name]
+			}
+			kAnon.Add(values)
 		}
 
 		err = outJsonWriter.Encode(jsonLine)
@@ -162,4 +550,35 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+
+	if err := guard.Check(); err != nil {
+		refuseOutput(out, cfg, err)
+	}
+
+	if kAnon != nil {
+		if err := writeKAnonymityReport(kAnon.Report(cfg.kAnonThreshold), cfg.kAnonReport); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Closing the output flushes any pending compressed data.
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if usage != nil {
+		if err := usage.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if rotator != nil {
+		// Each keyset was written when created, record the final batch sizes.
+		if err := rotator.WriteManifest(); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("data keysets written to %s", cfg.out+dek.ManifestSuffix)
+	}
+
+	appendAudit(cfg, audit.StatusOK)
 }
@@ -0,0 +1,191 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// keyset-chain prints a BigQuery SQL expression that decrypts the columns of
+// outputs encrypted with -dek-per-run or -tenant-field, choosing the keyset of
+// each batch or tenant from the key ID at the start of the ciphertext. i.e.
+//
+//	CREATE OR REPLACE FUNCTION dataset.decrypt_batches(encodedText STRING) AS (<expression>);
+//
+// For per-tenant keysets, the expression has a KEYS.KEYSET_CHAIN of each
+// keyset in -tenant-keyset-dir, so the BigQuery flow is:
+//
+//  1. Encrypt with -tenant-field and -tenant-keyset-dir, and load the output.
+//  2. Create the function with -tenant-keyset-dir and -function, and decrypt
+//     the columns with it, i.e. SELECT dataset.decrypt_tenants(Card_Number).
+//  3. After new tenants are encrypted or tenants are shredded, create the
+//     function again. The function holds a copy of every wrapped keyset, so
+//     the ciphertexts of a shredded tenant decrypt until it is replaced, and
+//     return NULL afterwards.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"encrypter-common/dek"
+	"encrypter-common/tenant"
+)
+
+// chain config
+type chainCfg struct {
+	manifests       string
+	manifestDir     string
+	tenantKeysetDir string
+	masterKeyURI    string
+	field           string
+	function        string
+	stripPadding    bool
+}
+
+func parseFlags() chainCfg {
+	var c chainCfg
+	flag.StringVar(&c.manifests, "manifest", "", "Comma-separated list of the <out>.dek.json manifests written by the encrypters with -dek-per-run.")
+	flag.StringVar(&c.manifestDir, "manifest-dir", "", "Directory whose <out>.dek.json manifests are all decrypted, as well as those of -manifest. The encrypters keep key IDs unique within the -dek-manifest-dir they write to.")
+	flag.StringVar(&c.tenantKeysetDir, "tenant-keyset-dir", "", "Directory of the per-tenant keysets given to the encrypters with -tenant-field. Every tenant keyset in it is decrypted, as well as the manifests.")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key the keysets of -tenant-keyset-dir are wrapped with, the first one given to the encrypters. i.e. 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY'")
+	flag.StringVar(&c.field, "field", "encodedText", "SQL expression of the base64 encoded ciphertext to decrypt, i.e. a column name.")
+	flag.BoolVar(&c.stripPadding, "strip-padding", false, "Strip the length-hiding padding added with the -pad-fields flag of the encrypters from the decrypted values. Only for functions decrypting padded columns, since it also strips unit separators (\\x1f) ending unpadded values.")
+	flag.StringVar(&c.function, "function", "", "Name of a BigQuery function to create, i.e. \"dataset.decrypt_batches\". The argument of the function is named after -field. The expression alone is printed when empty.")
+	flag.Parse()
+	if c.manifests == "" && c.manifestDir == "" && c.tenantKeysetDir == "" {
+		log.Fatal("manifest, manifest-dir or tenant-keyset-dir flag is missing.")
+	}
+	if c.tenantKeysetDir != "" && c.masterKeyURI == "" {
+		log.Fatal("URI of the master key of the tenant keysets is missing.")
+	}
+	return c
+}
+
+// bytesLiteral returns b as a BigQuery bytes literal.
+func bytesLiteral(b []byte) string {
+	var s strings.Builder
+	s.WriteString("b'")
+	for _, c := range b {
+		fmt.Fprintf(&s, "\\x%02x", c)
+	}
+	s.WriteString("'")
+	return s.String()
+}
+
+// wrappedKeyset returns the encrypted keyset of a tinkey keyset file, the
+// first level keyset of KEYS.KEYSET_CHAIN.
+func wrappedKeyset(keysetJSON []byte) ([]byte, error) {
+	var k struct {
+		EncryptedKeyset string `json:"encryptedKeyset"`
+	}
+	if err := json.Unmarshal(keysetJSON, &k); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(k.EncryptedKeyset)
+}
+
+// writeWhen writes the WHEN clause that decrypts the ciphertexts of a key ID.
+func writeWhen(expr *strings.Builder, field string, keyID uint32, masterKeyURI string, wrapped []byte) {
+	fmt.Fprintf(expr, "  WHEN %s THEN AEAD.DECRYPT_STRING(KEYS.KEYSET_CHAIN('%s', %s), FROM_BASE64(%s), '')\n",
+		bytesLiteral(binary.BigEndian.AppendUint32(nil, keyID)), masterKeyURI, bytesLiteral(wrapped), field)
+}
+
+func main() {
+	cfg := parseFlags()
+
+	var names []string
+	if cfg.manifests != "" {
+		names = strings.Split(cfg.manifests, ",")
+	}
+	if cfg.manifestDir != "" {
+		inDir, err := dek.Manifests(cfg.manifestDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names = append(names, inDir...)
+	}
+
+	var expr strings.Builder
+	fmt.Fprintf(&expr, "CASE SUBSTR(FROM_BASE64(%s), 2, 4)\n", cfg.field)
+	keyIDs := make(map[uint32]string)
+	read := make(map[string]bool)
+	for _, name := range names {
+		// A manifest may be given by name and be in -manifest-dir.
+		if read[filepath.Clean(name)] {
+			continue
+		}
+		read[filepath.Clean(name)] = true
+		m, err := dek.ReadManifest(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, b := range m.Batches {
+			batch := fmt.Sprintf("%s batch %d", name, b.Batch)
+			if other, ok := keyIDs[b.KeyID]; ok {
+				log.Fatalf("%s and %s have the same key ID %d, their ciphertexts can not be told apart", other, batch, b.KeyID)
+			}
+			keyIDs[b.KeyID] = batch
+
+			wrapped, err := wrappedKeyset(b.Keyset)
+			if err != nil {
+				log.Fatalf("%s: %v", batch, err)
+			}
+			fmt.Fprintf(&expr, "  -- %s: records %d to %d\n", batch, b.FirstRecord, b.FirstRecord+b.Records-1)
+			writeWhen(&expr, cfg.field, b.KeyID, m.MasterKeyURI, wrapped)
+		}
+	}
+	if cfg.tenantKeysetDir != "" {
+		tenantKeysets, err := tenant.Keysets(cfg.tenantKeysetDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range tenantKeysets {
+			keyID, err := tenant.PrimaryKeyID(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if other, ok := keyIDs[keyID]; ok {
+				log.Fatalf("%s and %s have the same key ID %d, their ciphertexts can not be told apart", other, name, keyID)
+			}
+			keyIDs[keyID] = name
+
+			b, err := os.ReadFile(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			wrapped, err := wrappedKeyset(b)
+			if err != nil {
+				log.Fatalf("%s: %v", name, err)
+			}
+			// Keyset file names do not reveal the tenant.
+			fmt.Fprintf(&expr, "  -- tenant keyset %s\n", filepath.Base(name))
+			writeWhen(&expr, cfg.field, keyID, cfg.masterKeyURI, wrapped)
+		}
+	}
+	expr.WriteString("END")
+	if cfg.stripPadding {
+		s := expr.String()
+		expr.Reset()
+		fmt.Fprintf(&expr, "REGEXP_REPLACE(\n%s,\nr'\\x1f+$', '')", s)
+	}
+
+	if cfg.function == "" {
+		fmt.Println(expr.String())
+		return
+	}
+	fmt.Printf("CREATE OR REPLACE FUNCTION %s(%s STRING) AS (\n%s\n);\n", cfg.function, cfg.field, expr.String())
+}
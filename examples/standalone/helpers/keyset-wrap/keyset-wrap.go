@@ -0,0 +1,94 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// keyset-wrap adds or removes the master keys a keyset file is wrapped with.
+// A keyset wrapped with several master keys, i.e. in different regions, can
+// be unwrapped by the encrypters with whichever master key is reachable.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"encrypter-common/keys"
+)
+
+// wrap config
+type wrapCfg struct {
+	keyset       string
+	masterKeyURI string
+	credentials  keys.Credentials
+	add          string
+	remove       string
+}
+
+func parseFlags() wrapCfg {
+	var c wrapCfg
+	flag.StringVar(&c.keyset, "keyset", "", "Keyset filename to update in place. A keyset created by tinkey create-keyset is converted to a multi-wrapped keyset.")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key the keyset is wrapped with. Required for a keyset created by tinkey create-keyset. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.add, "add", "", "Comma-separated list of master key URIs to wrap the keyset with. i.e. \"gcp-kms://projects/PROJECT_ID/locations/us-central1/keyRings/KEYRING/cryptoKeys/KEY\"")
+	flag.StringVar(&c.remove, "remove", "", "Comma-separated list of master key URIs to remove the wrapping of. The last wrapping can not be removed.")
+	flag.Parse()
+	if c.keyset == "" {
+		log.Fatal("Keyset filename is missing.")
+	}
+	return c
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+
+	m, err := keys.ReadMultiWrapped(cfg.keyset, cfg.masterKeyURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.add != "" {
+		handle, err := m.Unwrap(ctx, cfg.masterKeyURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, uri := range keys.MasterKeyURIs(cfg.add) {
+			if err := m.Add(ctx, handle, uri); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	for _, uri := range keys.MasterKeyURIs(cfg.remove) {
+		if err := m.Remove(uri); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cfg.add != "" || cfg.remove != "" {
+		// Check the keyset can still be unwrapped, so removing wrappings
+		// does not lock the keyset out.
+		if _, err := m.Unwrap(ctx, ""); err != nil {
+			log.Fatalf("%v\nRefusing to update %s.", err, cfg.keyset)
+		}
+		if err := m.WriteFile(cfg.keyset); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, w := range m.Wrappings {
+		fmt.Println(w.MasterKeyURI)
+	}
+}
@@ -0,0 +1,14 @@
+module pii-scanner
+
+go 1.23.0
+
+require (
+	encrypter-common v0.0.0
+)
+
+require (
+	github.com/klauspost/compress v1.18.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+)
+
+replace encrypter-common => ../encrypter-common
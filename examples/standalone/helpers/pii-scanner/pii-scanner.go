@@ -0,0 +1,266 @@
+// Copyright 2023-2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"encrypter-common/charset"
+	"encrypter-common/compress"
+	"encrypter-common/pii"
+)
+
+// scanner config
+type scanCfg struct {
+	in            string
+	format        string
+	delimiter     string
+	inputEncoding string
+	sampleSize    int
+	minLikelihood string
+	policy        string
+}
+
+// policy is the suggested field policy. Fields is ready to be passed to the
+// -fields flag of csv-encrypter and json-encrypter.
+type policy struct {
+	MinLikelihood string         `json:"min_likelihood"`
+	Fields        string         `json:"fields"`
+	Columns       []policyColumn `json:"columns"`
+}
+
+type policyColumn struct {
+	Name       string `json:"name"`
+	InfoType   string `json:"info_type,omitempty"`
+	Likelihood string `json:"likelihood,omitempty"`
+	Matches    int    `json:"matches"`
+	Samples    int    `json:"samples"`
+	Encrypt    bool   `json:"encrypt"`
+}
+
+func parseFlags() scanCfg {
+	var c scanCfg
+	flag.StringVar(&c.in, "in", "", "Filename to read csv or newline-delimited json data.")
+	flag.StringVar(&c.format, "format", "", "Format of the input: csv or json. Inferred from the input filename extension when not set.")
+	flag.StringVar(&c.delimiter, "delimiter", ",", "Field delimiter of the input csv. A single character, or \"tab\".")
+	flag.StringVar(&c.inputEncoding, "input-encoding", "utf-8", "Character set of the input. i.e. utf-8, latin1, windows-1252, shift-jis, utf-16 or another IANA name.")
+	flag.IntVar(&c.sampleSize, "sample-size", 1000, "Number of records to sample from the start of the input. 0 scans the whole input.")
+	flag.StringVar(&c.minLikelihood, "min-likelihood", "LIKELY", "Minimum likelihood for a column to be selected for encryption: VERY_UNLIKELY, UNLIKELY, POSSIBLE, LIKELY or VERY_LIKELY.")
+	flag.StringVar(&c.policy, "policy", "", "Filename to write the suggested field policy as JSON. Not written when empty.")
+	flag.Parse()
+	if c.in == "" {
+		log.Fatal("Input filename is missing.")
+	}
+	if c.format == "" {
+		c.format = formatFromFilename(c.in)
+	}
+	return c
+}
+
+// formatFromFilename infers the input format, ignoring compression extensions.
+func formatFromFilename(name string) string {
+	if compress.FromFilename(name) != compress.None {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".jsonl", ".ndjson":
+		return "json"
+	default:
+		return "csv"
+	}
+}
+
+// scanCsv runs the detectors over the columns of a csv file with a header row.
+func scanCsv(in io.Reader, c scanCfg) ([]*pii.Column, error) {
+	delimiter := ','
+	switch c.delimiter {
+	case "tab", `\t`:
+		delimiter = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(c.delimiter)
+		if r == utf8.RuneError || size != len(c.delimiter) {
+			return nil, fmt.Errorf("delimiter must be a single character, got %q", c.delimiter)
+		}
+		delimiter = r
+	}
+
+	r := csv.NewReader(in)
+	r.Comma = delimiter
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]*pii.Column, len(header))
+	for i, name := range header {
+		columns[i] = pii.NewColumn(name)
+	}
+
+	for count := 0; c.sampleSize == 0 || count < c.sampleSize; count++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range record {
+			columns[i].Add(value)
+		}
+	}
+	return columns, nil
+}
+
+// scanJson runs the detectors over the top-level keys of newline-delimited
+// json objects. Columns are sorted by name.
+func scanJson(in io.Reader, c scanCfg) ([]*pii.Column, error) {
+	d := json.NewDecoder(in)
+	d.UseNumber()
+	byName := make(map[string]*pii.Column)
+	for count := 0; c.sampleSize == 0 || count < c.sampleSize; count++ {
+		var object map[string]interface{}
+		if err := d.Decode(&object); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		for name, value := range object {
+			column, ok := byName[name]
+			if !ok {
+				column = pii.NewColumn(name)
+				byName[name] = column
+			}
+			switch v := value.(type) {
+			case nil:
+			case string:
+				column.Add(v)
+			case map[string]interface{}, []interface{}:
+				// Nested values are not scanned.
+			default:
+				column.Add(fmt.Sprint(v))
+			}
+		}
+	}
+
+	columns := make([]*pii.Column, 0, len(byName))
+	for _, column := range byName {
+		columns = append(columns, column)
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].Name < columns[j].Name
+	})
+	return columns, nil
+}
+
+func buildPolicy(columns []*pii.Column, minLikelihood pii.Likelihood) policy {
+	p := policy{MinLikelihood: minLikelihood.String()}
+	var fields []string
+	for _, column := range columns {
+		pc := policyColumn{Name: column.Name, Samples: column.Samples()}
+		if top, ok := column.Top(); ok {
+			pc.InfoType = top.InfoType
+			pc.Likelihood = top.Likelihood.String()
+			pc.Matches = top.Matches
+			pc.Encrypt = top.Likelihood >= minLikelihood
+		}
+		if pc.Encrypt {
+			fields = append(fields, column.Name)
+		}
+		p.Columns = append(p.Columns, pc)
+	}
+	p.Fields = strings.Join(fields, ",")
+	return p
+}
+
+func printReport(w io.Writer, columns []*pii.Column, p policy) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tINFO TYPE\tLIKELIHOOD\tMATCHES\tENCRYPT")
+	for i, column := range columns {
+		findings := column.Findings()
+		if len(findings) == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t%t\n", column.Name, false)
+			continue
+		}
+		for j, f := range findings {
+			name, encrypt := column.Name, fmt.Sprint(p.Columns[i].Encrypt)
+			if j > 0 {
+				name, encrypt = "", ""
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d/%d\t%s\n", name, f.InfoType, f.Likelihood, f.Matches, f.Samples, encrypt)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nSuggested fields (likelihood %s or higher):\n  -fields %q\n", p.MinLikelihood, p.Fields)
+	return err
+}
+
+func main() {
+	cfg := parseFlags()
+
+	minLikelihood, err := pii.ParseLikelihood(cfg.minLikelihood)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in, err := compress.Open(cfg.in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	decoded, err := charset.NewReader(in, cfg.inputEncoding)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var columns []*pii.Column
+	switch cfg.format {
+	case "csv":
+		columns, err = scanCsv(decoded, cfg)
+	case "json":
+		columns, err = scanJson(decoded, cfg)
+	default:
+		err = fmt.Errorf("unknown format %q, use csv or json", cfg.format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := buildPolicy(columns, minLikelihood)
+	if err := printReport(os.Stdout, columns, p); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.policy != "" {
+		b, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(cfg.policy, append(b, '\n'), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,569 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/tink"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"encrypter-common/audit"
+	"encrypter-common/compress"
+	"encrypter-common/keys"
+	"encrypter-common/ledger"
+	"encrypter-common/output"
+)
+
+var (
+	encrypter tink.AEAD
+	auditRun  *audit.Run
+	usage     *ledger.Ledger
+)
+
+// generator config
+type genCfg struct {
+	in                string
+	out               string
+	outFormat         string
+	descriptorSet     string
+	message           string
+	fields            string
+	keyset            string
+	keysetFingerprint string
+	masterKeyURI      string
+	credentials       keys.Credentials
+	keyLedger         string
+	keyMaxMessages    uint64
+	keyMaxAgeDays     int
+	keyLimitAction    string
+	auditLog          string
+	compress          string
+}
+
+// fieldPath is a path of fields from the top-level message to a nested field.
+type fieldPath []protoreflect.FieldDescriptor
+
+func (p fieldPath) String() string {
+	names := make([]string, len(p))
+	for i, fd := range p {
+		names[i] = string(fd.Name())
+	}
+	return strings.Join(names, ".")
+}
+
+// encryptOp encrypts a string or bytes field in place, or moves the
+// ciphertext to a bytes field of the same message when target is set.
+type encryptOp struct {
+	source fieldPath
+	target protoreflect.FieldDescriptor
+}
+
+func parseFlags() genCfg {
+	var c genCfg
+	flag.StringVar(&c.in, "in", "", "Filename to read length-delimited protobuf messages.")
+	flag.StringVar(&c.out, "out", "", "Filename to write encrypted data.")
+	flag.StringVar(&c.outFormat, "out-format", "protobuf", "The output format: protobuf (length-delimited), json, csv or avro. json, csv and avro have a string column per field, named by its path joined with underscores. Repeated fields, maps and recursive messages are written as JSON.")
+	flag.StringVar(&c.descriptorSet, "descriptor-set", "", "FileDescriptorSet of the message, i.e. the output of protoc --include_imports --descriptor_set_out.")
+	flag.StringVar(&c.message, "message", "", "Full name of the message type. i.e. \"payments.v1.CardEvent\"")
+	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of string or bytes field paths that need to be encrypted, as source[=target]. String fields get base64 ciphertext and bytes fields raw ciphertext. With a target, the ciphertext is moved to that bytes field of the same message and the source is cleared. i.e. \"card.number,card.pin=card.pin_ciphertext\"")
+	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data, or a keyset source: file://<path>, env://<variable> holding the keyset JSON or its base64 encoding, gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>] or vault-kv://<mount>/<path>[?version=<version>&field=<field>], i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset/versions/3\". Set KEYSET_CACHE_DIR to cache keysets fetched from Secret Manager or Vault.")
+	flag.StringVar(&c.keysetFingerprint, "keyset-fingerprint", "", "Fingerprint the keyset is pinned to, as logged by earlier runs or printed by verify-keyset. The run stops before encrypting any record if the keyset does not match, i.e. it was swapped. i.e. \"sha256:3b4c...\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.keyLedger, "key-usage-ledger", "", "Filename of a ledger counting the messages encrypted by each key ID, kept across runs. Keys are checked against -key-max-messages and -key-max-age-days. Disabled when empty.")
+	flag.Uint64Var(&c.keyMaxMessages, "key-max-messages", ledger.DefaultMaxMessages, "Number of messages a key may encrypt before it must be rotated, 0 for no limit. The default is the AES-GCM bound for random nonces.")
+	flag.IntVar(&c.keyMaxAgeDays, "key-max-age-days", 365, "Age in days past which a key must be rotated, 0 for no limit. A key is dated by the modification time of its keyset file when first recorded in the ledger.")
+	flag.StringVar(&c.keyLimitAction, "key-limit-action", "warn", "What to do when a key exceeds -key-max-messages or -key-max-age-days: warn, or refuse to encrypt.")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set. Compressed input is always detected automatically.")
+	flag.Parse()
+	if c.descriptorSet == "" {
+		log.Fatal("Descriptor set filename is missing.")
+	}
+	if c.message == "" {
+		log.Fatal("Message type name is missing.")
+	}
+	if c.fields == "" {
+		log.Fatal("fields flag is missing. Please set fields flag that is a comma-separated list of field paths that need to be encrypted. i.e. -fields \"card.number,card.pin\"")
+	}
+	if c.keyset == "" {
+		log.Fatal("Keyset filename to be used to encrypt the data is missing.")
+	}
+	if c.keyLimitAction != "warn" && c.keyLimitAction != "refuse" {
+		log.Fatalf("invalid key-limit-action %q, use warn or refuse.", c.keyLimitAction)
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	if c.in == "" {
+		log.Fatal("Input filename is missing.")
+	}
+	if c.out == "" {
+		log.Fatal("Output filename is missing.")
+	}
+	return c
+}
+
+func setupKeyset(ctx context.Context, c genCfg) {
+	var err error
+	if c.keyLedger != "" {
+		usage, err = ledger.Open(c.keyLedger, ledger.Policy{
+			MaxMessages: c.keyMaxMessages,
+			MaxAge:      time.Duration(c.keyMaxAgeDays) * 24 * time.Hour,
+			Refuse:      c.keyLimitAction == "refuse",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keyHandle, err := keys.VerifyKeyset(ctx, c.keyset, c.masterKeyURI, keys.Expect{KeyType: "AES256_GCM", Fingerprint: c.keysetFingerprint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if usage != nil {
+		k, err := keys.FetchKeyset(ctx, c.keyset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := usage.Check(keyHandle.KeysetInfo().GetPrimaryKeyId(), k.Created); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	encrypter, err = aead.New(keyHandle)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func encryptBytes(data []byte) []byte {
+	encryptionContext := []byte("")
+
+	encryptedData, err := encrypter.Encrypt(data, encryptionContext)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auditRun != nil {
+		auditRun.Encrypted(encryptedData)
+	}
+	if usage != nil {
+		if err := usage.Record(encryptedData); err != nil {
+			// Keep the counts of the messages encrypted so far.
+			if err := usage.Save(); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+	}
+
+	return encryptedData
+}
+
+func encryptData(data string) string {
+	return base64.StdEncoding.EncodeToString(encryptBytes([]byte(data)))
+}
+
+// loadMessageDescriptor finds the message type in a FileDescriptorSet file.
+func loadMessageDescriptor(descriptorSet, message string) (protoreflect.MessageDescriptor, error) {
+	b, err := os.ReadFile(descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &fds); err != nil {
+		return nil, fmt.Errorf("reading descriptor set %s: %w", descriptorSet, err)
+	}
+	files, err := protodesc.NewFiles(&fds)
+	if err != nil {
+		return nil, fmt.Errorf("reading descriptor set %s: %w", descriptorSet, err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %q not found in %s: %w", message, descriptorSet, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message", message)
+	}
+	return md, nil
+}
+
+// resolvePath resolves a dot-separated field path. Intermediate fields must
+// be messages, which can be repeated, and the last one a string or bytes field.
+func resolvePath(md protoreflect.MessageDescriptor, path string) (fieldPath, error) {
+	var p fieldPath
+	names := strings.Split(strings.TrimSpace(path), ".")
+	for i, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("field path %q: %s has no field %q", path, md.FullName(), name)
+		}
+		if fd.IsMap() {
+			return nil, fmt.Errorf("field path %q: map field %q is not supported", path, name)
+		}
+		p = append(p, fd)
+
+		if i < len(names)-1 {
+			if fd.Message() == nil {
+				return nil, fmt.Errorf("field path %q: %q is not a message", path, name)
+			}
+			md = fd.Message()
+			continue
+		}
+		if fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BytesKind {
+			return nil, fmt.Errorf("field path %q: only string and bytes fields can be encrypted, %q is %s", path, name, fd.Kind())
+		}
+	}
+	return p, nil
+}
+
+func parseEncryptOps(md protoreflect.MessageDescriptor, fields string) ([]encryptOp, error) {
+	var ops []encryptOp
+	for _, spec := range strings.Split(fields, ",") {
+		source, target, move := strings.Cut(spec, "=")
+		sourcePath, err := resolvePath(md, source)
+		if err != nil {
+			return nil, err
+		}
+		op := encryptOp{source: sourcePath}
+
+		if move {
+			targetPath, err := resolvePath(md, target)
+			if err != nil {
+				return nil, err
+			}
+			op.target = targetPath[len(targetPath)-1]
+			if len(targetPath) != len(sourcePath) || targetPath[:len(targetPath)-1].String() != sourcePath[:len(sourcePath)-1].String() {
+				return nil, fmt.Errorf("%q and %q must be fields of the same message", source, target)
+			}
+			if op.target.Kind() != protoreflect.BytesKind || op.target.IsList() || sourcePath[len(sourcePath)-1].IsList() {
+				return nil, fmt.Errorf("%q must be a singular bytes field to move %q into", target, source)
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// apply encrypts the field in m. Unset fields are left unset.
+func (op encryptOp) apply(m protoreflect.Message, path fieldPath) {
+	fd := path[0]
+	if !m.Has(fd) {
+		return
+	}
+
+	if len(path) > 1 {
+		if fd.IsList() {
+			list := m.Mutable(fd).List()
+			for i := 0; i < list.Len(); i++ {
+				op.apply(list.Get(i).Message(), path[1:])
+			}
+			return
+		}
+		op.apply(m.Mutable(fd).Message(), path[1:])
+		return
+	}
+
+	if op.target != nil {
+		var plaintext []byte
+		if fd.Kind() == protoreflect.StringKind {
+			plaintext = []byte(m.Get(fd).String())
+		} else {
+			plaintext = m.Get(fd).Bytes()
+		}
+		m.Set(op.target, protoreflect.ValueOfBytes(encryptBytes(plaintext)))
+		m.Clear(fd)
+		return
+	}
+
+	encrypt := func(v protoreflect.Value) protoreflect.Value {
+		if fd.Kind() == protoreflect.StringKind {
+			return protoreflect.ValueOfString(encryptData(v.String()))
+		}
+		return protoreflect.ValueOfBytes(encryptBytes(v.Bytes()))
+	}
+	if fd.IsList() {
+		list := m.Mutable(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			list.Set(i, encrypt(list.Get(i)))
+		}
+		return
+	}
+	m.Set(fd, encrypt(m.Get(fd)))
+}
+
+// flatColumns returns a column for every scalar field reachable through
+// singular, non-recursive message fields.
+func flatColumns(md protoreflect.MessageDescriptor, prefix fieldPath, visiting map[protoreflect.FullName]bool) []fieldPath {
+	visiting[md.FullName()] = true
+	defer delete(visiting, md.FullName())
+
+	var columns []fieldPath
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		path := append(append(fieldPath{}, prefix...), fd)
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() && !visiting[fd.Message().FullName()] {
+			columns = append(columns, flatColumns(fd.Message(), path, visiting)...)
+			continue
+		}
+		columns = append(columns, path)
+	}
+	return columns
+}
+
+func columnName(p fieldPath) string {
+	return strings.ReplaceAll(p.String(), ".", "_")
+}
+
+// flatValue returns the value of a column as a string.
+func flatValue(m protoreflect.Message, path fieldPath) (string, error) {
+	for _, fd := range path[:len(path)-1] {
+		if !m.Has(fd) {
+			return "", nil
+		}
+		m = m.Get(fd).Message()
+	}
+
+	fd := path[len(path)-1]
+	switch {
+	case fd.IsList() || fd.IsMap() || fd.Message() != nil:
+		if !m.Has(fd) {
+			return "", nil
+		}
+		v, err := jsonValue(fd, m.Get(fd))
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	default:
+		return scalarString(fd, m.Get(fd)), nil
+	}
+}
+
+// jsonValue converts a field value to a value for encoding/json.
+func jsonValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, error) {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		values := make([]interface{}, list.Len())
+		for i := range values {
+			var err error
+			if values[i], err = singularJSONValue(fd, list.Get(i)); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case fd.IsMap():
+		values := make(map[string]interface{})
+		var err error
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			values[k.String()], err = singularJSONValue(fd.MapValue(), mv)
+			return err == nil
+		})
+		return values, err
+	default:
+		return singularJSONValue(fd, v)
+	}
+}
+
+func singularJSONValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, error) {
+	if fd.Message() != nil {
+		b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(v.Message().Interface())
+		return json.RawMessage(b), err
+	}
+	return scalarString(fd, v), nil
+}
+
+// scalarString formats a scalar value, with bytes in base64 and enums by name.
+func scalarString(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(v.Bool())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(v.Uint(), 10)
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	default:
+		return v.String()
+	}
+}
+
+// messageWriter writes messages as length-delimited protobuf or as flat records.
+type messageWriter struct {
+	w       io.Writer
+	flat    output.Writer
+	columns []fieldPath
+}
+
+func newMessageWriter(w io.Writer, format string, md protoreflect.MessageDescriptor) (*messageWriter, error) {
+	if strings.EqualFold(format, "protobuf") {
+		return &messageWriter{w: w}, nil
+	}
+
+	columns := flatColumns(md, nil, make(map[protoreflect.FullName]bool))
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = columnName(c)
+	}
+	flat, err := output.NewWriter(w, format, names)
+	if err != nil {
+		return nil, err
+	}
+	return &messageWriter{flat: flat, columns: columns}, nil
+}
+
+func (mw *messageWriter) Write(m protoreflect.Message) error {
+	if mw.flat == nil {
+		_, err := protodelim.MarshalTo(mw.w, m.Interface())
+		return err
+	}
+
+	record := make([]string, len(mw.columns))
+	for i, c := range mw.columns {
+		var err error
+		if record[i], err = flatValue(m, c); err != nil {
+			return err
+		}
+	}
+	return mw.flat.Write(record)
+}
+
+func (mw *messageWriter) Close() error {
+	if mw.flat == nil {
+		return nil
+	}
+	return mw.flat.Close()
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.auditLog != "" {
+		auditRun = audit.Start("proto-encrypter", "encrypt")
+		auditRun.Input.Name, auditRun.Output.Name = cfg.in, cfg.out
+		auditRun.Columns = strings.Split(cfg.fields, ",")
+		auditRun.MasterKeyURI = cfg.masterKeyURI
+	}
+	setupKeyset(ctx, cfg)
+
+	md, err := loadMessageDescriptor(cfg.descriptorSet, cfg.message)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ops, err := parseEncryptOps(md, cfg.fields)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in, err := compress.Open(cfg.in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	inReader := bufio.NewReader(in)
+
+	out, err := compress.Create(cfg.out, cfg.compress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outWriter, err := newMessageWriter(out, cfg.outFormat, md)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for count := 1; ; count++ {
+		m := dynamicpb.NewMessage(md)
+		err := protodelim.UnmarshalFrom(inReader, m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("message %d: %v", count, err)
+		}
+
+		for _, op := range ops {
+			op.apply(m, op.source)
+		}
+
+		if err := outWriter.Write(m); err != nil {
+			log.Fatalf("message %d: %v", count, err)
+		}
+	}
+
+	if err := outWriter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	// Closing the output flushes any pending compressed data.
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if usage != nil {
+		if err := usage.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if auditRun != nil {
+		if err := audit.Append(cfg.auditLog, auditRun); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -17,6 +17,10 @@
 set -e
 set -u
 
-file_name=$(cat - | jq '.key_file' --raw-output)
-encryptedKeyset=$(jq '.encryptedKeyset' --raw-output < "$file_name" | base64 - --decode --wrap 0 | od -An --format=o1 - | tr -d '\n' | sed -e 's/\s/\\\\/g')
+query=$(cat -)
+file_name=$(echo "$query" | jq '.key_file' --raw-output)
+master_key_uri=$(echo "$query" | jq '.master_key_uri // ""' --raw-output)
+# A multi-wrapped keyset file holds the keyset wrapped with several master keys,
+# use the one wrapped with the master key of the decrypt function.
+encryptedKeyset=$(jq --arg uri "$master_key_uri" '.encryptedKeyset // (.wrappings[] | select(.masterKeyUri == $uri) | .keyset.encryptedKeyset)' --raw-output < "$file_name" | base64 - --decode --wrap 0 | od -An --format=o1 - | tr -d '\n' | sed -e 's/\s/\\\\/g')
 jq -n --arg encryptedKeyset "$encryptedKeyset" '{"encryptedKeyset":"'"$encryptedKeyset"'"}'
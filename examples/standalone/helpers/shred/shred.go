@@ -0,0 +1,98 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// shred destroys the keysets of tenants written with -tenant-field in
+// -tenant-keyset-dir, so the data encrypted for them can no longer be
+// decrypted with it, i.e. to honor a request to be forgotten without
+// rewriting the tables.
+//
+// Copies of the keysets still decrypt the data until they are deleted too:
+// backups and object versions of the directory, and the BigQuery functions
+// created with keyset-chain, which hold the wrapped keysets in their
+// KEYS.KEYSET_CHAIN expressions. Create those functions again with
+// keyset-chain after shredding.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"encrypter-common/keys"
+	"encrypter-common/tenant"
+)
+
+// shred config
+type shredCfg struct {
+	tenant       string
+	keysetDir    string
+	masterKeyURI string
+	credentials  keys.Credentials
+}
+
+func parseFlags() shredCfg {
+	var c shredCfg
+	flag.StringVar(&c.tenant, "tenant", "", "Tenant whose keyset is destroyed, the value of the -tenant-field of the encrypters. When empty, tenants are read from stdin, one per line.")
+	flag.StringVar(&c.keysetDir, "tenant-keyset-dir", "", "Directory of the per-tenant keysets, the same one given to -tenant-keyset-dir of the encrypters.")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key the keysets are wrapped with, the same one given to the encrypters. It unwraps the names keyset the keyset file of a tenant is found with.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.Parse()
+	if c.keysetDir == "" {
+		log.Fatal("tenant-keyset-dir flag is missing.")
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	return c
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	store, err := tenant.Open(ctx, cfg.keysetDir, cfg.masterKeyURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shred := func(t string) {
+		name, err := store.KeysetFile(t)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := store.Shred(t); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("tenant %q shredded, %s destroyed", t, name)
+	}
+
+	if cfg.tenant != "" {
+		shred(cfg.tenant)
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				shred(scanner.Text())
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("Copies of the keysets still decrypt the data: create the decrypt functions again with keyset-chain -tenant-keyset-dir %s, and delete the backups and object versions of the keysets.", cfg.keysetDir)
+}
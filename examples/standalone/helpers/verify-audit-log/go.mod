@@ -0,0 +1,9 @@
+module verify-audit-log
+
+go 1.23.0
+
+require (
+	encrypter-common v0.0.0
+)
+
+replace encrypter-common => ../encrypter-common
@@ -0,0 +1,142 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// verify-audit-log checks the hash chain of the audit log the encrypters and
+// decrypters append to with -audit-log, and exports its records as JSON lines
+// in the structured logging format of Cloud Logging, i.e. for the Ops Agent
+// to ship them to a log bucket outside the host.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"encrypter-common/audit"
+)
+
+// verify config
+type verifyCfg struct {
+	auditLog   string
+	expectHash string
+	export     string
+	exportFrom uint64
+}
+
+func parseFlags() verifyCfg {
+	var c verifyCfg
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to verify. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.expectHash, "expect-hash", "", "Hash of a record noted earlier, i.e. the last one exported. The log must still hold it, which detects records removed from its end.")
+	flag.StringVar(&c.export, "export", "", "Filename to write the records to as Cloud Logging structured JSON lines, - for the standard output. The log is verified first.")
+	flag.Uint64Var(&c.exportFrom, "export-from", 1, "Sequence number of the first record to export, to export only the records appended since the last export.")
+	flag.Parse()
+	if c.auditLog == "" {
+		log.Fatal("Audit log filename is missing.")
+	}
+	return c
+}
+
+// entry is a record in the structured logging format of Cloud Logging, its
+// special fields set from the record and the others kept in the payload.
+func entry(rec audit.Record) (map[string]any, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	var e map[string]any
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	e["severity"] = "NOTICE"
+	if rec.Status != "" && rec.Status != audit.StatusOK {
+		e["severity"] = "WARNING"
+	}
+	e["time"] = rec.Finished
+	e["message"] = fmt.Sprintf("%s %s %s by %s@%s", rec.Tool, rec.Operation, rec.Input.Name, rec.User, rec.Host)
+	e["logging.googleapis.com/insertId"] = rec.Hash
+	e["logging.googleapis.com/labels"] = map[string]string{
+		"tool":      rec.Tool,
+		"operation": rec.Operation,
+		"user":      rec.User,
+		"host":      rec.Host,
+		"status":    rec.Status,
+	}
+	return e, nil
+}
+
+func export(records []audit.Record, from uint64, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	count := 0
+	for _, rec := range records {
+		if rec.Seq < from {
+			continue
+		}
+		e, err := entry(rec)
+		if err != nil {
+			return count, err
+		}
+		if err := enc.Encode(e); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func main() {
+	cfg := parseFlags()
+
+	records, err := audit.Read(cfg.auditLog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := audit.Verify(records); err != nil {
+		log.Fatalf("%s was tampered with: %v", cfg.auditLog, err)
+	}
+	if cfg.expectHash != "" {
+		found := false
+		for _, rec := range records {
+			found = found || rec.Hash == cfg.expectHash
+		}
+		if !found {
+			log.Fatalf("%s was tampered with: it has no record with hash %s, records were removed from its end", cfg.auditLog, cfg.expectHash)
+		}
+	}
+	head := ""
+	if len(records) > 0 {
+		head = records[len(records)-1].Hash
+	}
+	log.Printf("%s verified: %d records, last hash %s", cfg.auditLog, len(records), head)
+
+	if cfg.export == "" {
+		return
+	}
+	var w io.WriteCloser = os.Stdout
+	if cfg.export != "-" {
+		if w, err = os.Create(cfg.export); err != nil {
+			log.Fatal(err)
+		}
+	}
+	count, err := export(records, cfg.exportFrom, w)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d records exported to %s", count, cfg.export)
+}
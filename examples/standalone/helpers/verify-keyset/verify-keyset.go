@@ -0,0 +1,83 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// verify-keyset checks a keyset before it is used: that it unwraps with the
+// master key, that its primary key has the expected type, that it passes a
+// self-test and that it matches a pinned fingerprint. The encrypters run the
+// same checks before encrypting any record. It prints the fingerprint to pin
+// with -keyset-fingerprint.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"encrypter-common/keys"
+)
+
+// verify config
+type verifyCfg struct {
+	keyset       string
+	masterKeyURI string
+	credentials  keys.Credentials
+	keyType      string
+	fingerprint  string
+}
+
+func parseFlags() verifyCfg {
+	var c verifyCfg
+	var types []string
+	for name := range keys.KeyTypes {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+
+	flag.StringVar(&c.keyset, "keyset", "", "Keyset filename or source to verify, as given to the encrypters with -keyset. i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key the keyset is wrapped with. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY'. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.keyType, "key-type", "AES256_GCM", fmt.Sprintf("Key template the primary key must have been created with: %s. AES256_GCM for the -keyset of the encrypters, HMAC_SHA256_PRF for the blind index, format-preserving encryption and date shift keysets.", strings.Join(types, ", ")))
+	flag.StringVar(&c.fingerprint, "fingerprint", "", "Fingerprint the keyset is pinned to, as given to the encrypters with -keyset-fingerprint. i.e. \"sha256:3b4c...\"")
+	flag.Parse()
+	if c.keyset == "" {
+		log.Fatal("Keyset filename is missing.")
+	}
+	return c
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+
+	handle, err := keys.VerifyKeyset(ctx, cfg.keyset, cfg.masterKeyURI, keys.Expect{KeyType: cfg.keyType, Fingerprint: cfg.fingerprint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fingerprint, err := keys.Fingerprint(handle)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	info := handle.KeysetInfo()
+	fmt.Printf("keyset:         %s\n", cfg.keyset)
+	fmt.Printf("primary key id: %d\n", info.GetPrimaryKeyId())
+	fmt.Printf("keys:           %d\n", len(info.GetKeyInfo()))
+	fmt.Printf("fingerprint:    %s\n", fingerprint)
+}
@@ -0,0 +1,392 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/tink"
+	"github.com/xuri/excelize/v2"
+
+	"encrypter-common/audit"
+	"encrypter-common/compress"
+	"encrypter-common/keys"
+	"encrypter-common/ledger"
+	"encrypter-common/output"
+)
+
+var (
+	encrypter tink.AEAD
+	auditRun  *audit.Run
+	usage     *ledger.Ledger
+
+	// dateTokens matches the date and time parts of a custom number format,
+	// once quoted text, escapes and [colour] or [$-locale] sections are removed.
+	dateTokens  = regexp.MustCompile(`(?i)[ydhms]`)
+	formatNoise = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+)
+
+// generator config
+type genCfg struct {
+	in                string
+	out               string
+	outFormat         string
+	sheet             string
+	headerRow         int
+	fields            string
+	keyset            string
+	keysetFingerprint string
+	masterKeyURI      string
+	credentials       keys.Credentials
+	keyLedger         string
+	keyMaxMessages    uint64
+	keyMaxAgeDays     int
+	keyLimitAction    string
+	auditLog          string
+	compress          string
+}
+
+func parseFlags() genCfg {
+	var c genCfg
+	flag.StringVar(&c.in, "in", "", "Filename to read the xlsx workbook.")
+	flag.StringVar(&c.out, "out", "", "Filename to write encrypted data.")
+	flag.StringVar(&c.outFormat, "out-format", "csv", "The output format: csv, json or avro. All values are written as strings.")
+	flag.StringVar(&c.sheet, "sheet", "", "Name or 1-based number of the sheet to read. Defaults to the first sheet.")
+	flag.IntVar(&c.headerRow, "header-row", 1, "1-based row number of the header. Rows above the header are skipped.")
+	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of header names that need to be encrypted. i.e. \"Card Type Full Name,Issuing Bank\"")
+	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data, or a keyset source: file://<path>, env://<variable> holding the keyset JSON or its base64 encoding, gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>] or vault-kv://<mount>/<path>[?version=<version>&field=<field>], i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset/versions/3\". Set KEYSET_CACHE_DIR to cache keysets fetched from Secret Manager or Vault.")
+	flag.StringVar(&c.keysetFingerprint, "keyset-fingerprint", "", "Fingerprint the keyset is pinned to, as logged by earlier runs or printed by verify-keyset. The run stops before encrypting any record if the keyset does not match, i.e. it was swapped. i.e. \"sha256:3b4c...\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.keyLedger, "key-usage-ledger", "", "Filename of a ledger counting the messages encrypted by each key ID, kept across runs. Keys are checked against -key-max-messages and -key-max-age-days. Disabled when empty.")
+	flag.Uint64Var(&c.keyMaxMessages, "key-max-messages", ledger.DefaultMaxMessages, "Number of messages a key may encrypt before it must be rotated, 0 for no limit. The default is the AES-GCM bound for random nonces.")
+	flag.IntVar(&c.keyMaxAgeDays, "key-max-age-days", 365, "Age in days past which a key must be rotated, 0 for no limit. A key is dated by the modification time of its keyset file when first recorded in the ledger.")
+	flag.StringVar(&c.keyLimitAction, "key-limit-action", "warn", "What to do when a key exceeds -key-max-messages or -key-max-age-days: warn, or refuse to encrypt.")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set.")
+	flag.Parse()
+	if c.fields == "" {
+		log.Fatal("fields flag is missing. Please set fields flag that is a comma-separated list of header names that need to be encrypted. i.e. -fields \"Card Type Full Name,Issuing Bank\"")
+	}
+	if c.headerRow < 1 {
+		log.Fatal("header-row must be 1 or greater.")
+	}
+	if c.keyset == "" {
+		log.Fatal("Keyset filename to be used to encrypt the data is missing.")
+	}
+	if c.keyLimitAction != "warn" && c.keyLimitAction != "refuse" {
+		log.Fatalf("invalid key-limit-action %q, use warn or refuse.", c.keyLimitAction)
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	if c.in == "" {
+		log.Fatal("Input xlsx filename is missing.")
+	}
+	if c.out == "" {
+		log.Fatal("Output filename is missing.")
+	}
+	return c
+}
+
+func setupKeyset(ctx context.Context, c genCfg) {
+	var err error
+	if c.keyLedger != "" {
+		usage, err = ledger.Open(c.keyLedger, ledger.Policy{
+			MaxMessages: c.keyMaxMessages,
+			MaxAge:      time.Duration(c.keyMaxAgeDays) * 24 * time.Hour,
+			Refuse:      c.keyLimitAction == "refuse",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keyHandle, err := keys.VerifyKeyset(ctx, c.keyset, c.masterKeyURI, keys.Expect{KeyType: "AES256_GCM", Fingerprint: c.keysetFingerprint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if usage != nil {
+		k, err := keys.FetchKeyset(ctx, c.keyset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := usage.Check(keyHandle.KeysetInfo().GetPrimaryKeyId(), k.Created); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	encrypter, err = aead.New(keyHandle)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func encryptData(data string) string {
+	dataInBytes := []byte(data)
+	encryptionContext := []byte("")
+
+	encryptedData, err := encrypter.Encrypt(dataInBytes, encryptionContext)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auditRun != nil {
+		auditRun.Encrypted(encryptedData)
+	}
+	if usage != nil {
+		if err := usage.Record(encryptedData); err != nil {
+			// Keep the counts of the messages encrypted so far.
+			if err := usage.Save(); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(encryptedData)
+}
+
+// selectSheet resolves the sheet flag, a sheet name or a 1-based sheet number.
+func selectSheet(f *excelize.File, sheet string) (string, error) {
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+	if sheet == "" {
+		return sheets[0], nil
+	}
+	for _, name := range sheets {
+		if strings.EqualFold(name, sheet) {
+			return name, nil
+		}
+	}
+	if n, err := strconv.Atoi(sheet); err == nil && n >= 1 && n <= len(sheets) {
+		return sheets[n-1], nil
+	}
+	return "", fmt.Errorf("sheet %q not found, the workbook has: %s", sheet, strings.Join(sheets, ", "))
+}
+
+// cellReader converts cells to strings: text as is, numbers without display
+// formatting, dates and times in ISO 8601 and booleans as true or false.
+type cellReader struct {
+	f          *excelize.File
+	sheet      string
+	date1904   bool
+	dateStyles map[int]bool
+}
+
+func newCellReader(f *excelize.File, sheet string) (*cellReader, error) {
+	props, err := f.GetWorkbookProps()
+	if err != nil {
+		return nil, err
+	}
+	c := &cellReader{f: f, sheet: sheet, dateStyles: make(map[int]bool)}
+	if props.Date1904 != nil {
+		c.date1904 = *props.Date1904
+	}
+	return c, nil
+}
+
+// isDateStyle reports whether a cell style has a date or time number format.
+func (c *cellReader) isDateStyle(styleID int) bool {
+	if isDate, ok := c.dateStyles[styleID]; ok {
+		return isDate
+	}
+
+	isDate := false
+	if style, err := c.f.GetStyle(styleID); err == nil {
+		switch {
+		case style.CustomNumFmt != nil:
+			format := formatNoise.ReplaceAllString(*style.CustomNumFmt, "")
+			isDate = !strings.EqualFold(format, "General") && dateTokens.MatchString(format)
+		case style.NumFmt >= 14 && style.NumFmt <= 22,
+			style.NumFmt >= 27 && style.NumFmt <= 36,
+			style.NumFmt >= 45 && style.NumFmt <= 47,
+			style.NumFmt >= 50 && style.NumFmt <= 58:
+			isDate = true
+		}
+	}
+	c.dateStyles[styleID] = isDate
+	return isDate
+}
+
+func (c *cellReader) value(raw string, col, row int) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	cell, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return "", err
+	}
+
+	cellType, err := c.f.GetCellType(c.sheet, cell)
+	if err != nil {
+		return "", err
+	}
+	if cellType == excelize.CellTypeBool {
+		return strconv.FormatBool(raw == "1" || strings.EqualFold(raw, "true")), nil
+	}
+
+	number, err := strconv.ParseFloat(raw, 64)
+	if err != nil || cellType == excelize.CellTypeSharedString || cellType == excelize.CellTypeInlineString {
+		return raw, nil
+	}
+
+	styleID, err := c.f.GetCellStyle(c.sheet, cell)
+	if err != nil || !c.isDateStyle(styleID) {
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	}
+
+	t, err := excelize.ExcelDateToTime(number, c.date1904)
+	if err != nil {
+		return "", fmt.Errorf("cell %s: %w", cell, err)
+	}
+	switch {
+	case number < 1:
+		return t.Format("15:04:05"), nil
+	case number == float64(int64(number)):
+		return t.Format("2006-01-02"), nil
+	default:
+		return t.Format("2006-01-02 15:04:05"), nil
+	}
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.auditLog != "" {
+		auditRun = audit.Start("xlsx-encrypter", "encrypt")
+		auditRun.Input.Name, auditRun.Output.Name = cfg.in, cfg.out
+		auditRun.Columns = strings.Split(cfg.fields, ",")
+		auditRun.MasterKeyURI = cfg.masterKeyURI
+	}
+	setupKeyset(ctx, cfg)
+
+	f, err := excelize.OpenFile(cfg.in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	sheet, err := selectSheet(f, cfg.sheet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cells, err := newCellReader(f, sheet)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rows, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(rows) < cfg.headerRow {
+		log.Fatalf("sheet %q has %d rows, no header at row %d", sheet, len(rows), cfg.headerRow)
+	}
+
+	header := rows[cfg.headerRow-1]
+	for len(header) > 0 && strings.TrimSpace(header[len(header)-1]) == "" {
+		header = header[:len(header)-1]
+	}
+	for index, name := range header {
+		if strings.TrimSpace(name) == "" {
+			log.Fatalf("header row %d: column %d has no name", cfg.headerRow, index+1)
+		}
+	}
+
+	headersToEncryptMap := make(map[string]int)
+	for _, val := range strings.Split(cfg.fields, ",") {
+		headersToEncryptMap[strings.ToLower(val)] = 0
+	}
+	headersToEncrypt := make(map[int]int)
+	for index, value := range header {
+		if _, hasKeyInMap := headersToEncryptMap[strings.ToLower(value)]; hasKeyInMap {
+			headersToEncrypt[index] = 0
+		}
+	}
+
+	out, err := compress.Create(cfg.out, cfg.compress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outWriter, err := output.NewWriter(out, cfg.outFormat, header)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for rowIndex := cfg.headerRow; rowIndex < len(rows); rowIndex++ {
+		row := rows[rowIndex]
+		rowNumber := rowIndex + 1
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+
+		record := make([]string, len(header))
+		for col, raw := range row {
+			if col >= len(header) {
+				if strings.TrimSpace(raw) != "" {
+					log.Fatalf("row %d: column %d has a value but no header", rowNumber, col+1)
+				}
+				continue
+			}
+			if record[col], err = cells.value(raw, col+1, rowNumber); err != nil {
+				log.Fatalf("row %d: %v", rowNumber, err)
+			}
+		}
+
+		for colToEncryptIndex := range headersToEncrypt {
+			record[colToEncryptIndex] = encryptData(record[colToEncryptIndex])
+		}
+
+		if err := outWriter.Write(record); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := outWriter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	// Closing the output flushes any pending compressed data.
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if usage != nil {
+		if err := usage.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if auditRun != nil {
+		if err := audit.Append(cfg.auditLog, auditRun); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
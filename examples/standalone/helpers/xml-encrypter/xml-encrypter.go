@@ -0,0 +1,419 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/tink"
+
+	"encrypter-common/audit"
+	"encrypter-common/charset"
+	"encrypter-common/compress"
+	"encrypter-common/keys"
+	"encrypter-common/ledger"
+	"encrypter-common/output"
+)
+
+var (
+	encrypter tink.AEAD
+	auditRun  *audit.Run
+	usage     *ledger.Ledger
+)
+
+// generator config
+type genCfg struct {
+	in                string
+	out               string
+	outFormat         string
+	record            string
+	columns           string
+	fields            string
+	keyset            string
+	keysetFingerprint string
+	masterKeyURI      string
+	credentials       keys.Credentials
+	keyLedger         string
+	keyMaxMessages    uint64
+	keyMaxAgeDays     int
+	keyLimitAction    string
+	auditLog          string
+	compress          string
+}
+
+// node is an XML element of a record.
+type node struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []node     `xml:",any"`
+}
+
+// column is an output column and the path of its value inside a record.
+type column struct {
+	name string
+	path []string
+}
+
+func parseFlags() genCfg {
+	var c genCfg
+	flag.StringVar(&c.in, "in", "", "Filename to read xml data.")
+	flag.StringVar(&c.out, "out", "", "Filename to write encrypted data.")
+	flag.StringVar(&c.outFormat, "out-format", "json", "The output format: json (newline-delimited, as read by the Pub/Sub flex template), csv or avro. All values are written as strings.")
+	flag.StringVar(&c.record, "record", "", "Path of the repeating record element. An absolute path, i.e. \"/Export/Cards/Card\", or an element name at any depth, i.e. \"//Card\" or \"Card\".")
+	flag.StringVar(&c.columns, "columns", "", "Comma-separated list of output columns as [name=]path, relative to the record element. A path has element steps, optionally with a 1-based index, and can end with @attribute. i.e. \"Card_Number=Number,Card_Holders_Name=Holder/Name,Issuing_Bank=@bank,Phone[2]\"")
+	flag.StringVar(&c.fields, "fields", "", "Comma-separated list of output column names that need to be encrypted. i.e. \"Card_Number,Card_Holders_Name\"")
+	flag.StringVar(&c.keyset, "keyset", "keyset", "Keyset filename to be used to encrypt the data, or a keyset source: file://<path>, env://<variable> holding the keyset JSON or its base64 encoding, gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>] or vault-kv://<mount>/<path>[?version=<version>&field=<field>], i.e. \"gcp-secretmanager://projects/my-project/secrets/data-keyset/versions/3\". Set KEYSET_CACHE_DIR to cache keysets fetched from Secret Manager or Vault.")
+	flag.StringVar(&c.keysetFingerprint, "keyset-fingerprint", "", "Fingerprint the keyset is pinned to, as logged by earlier runs or printed by verify-keyset. The run stops before encrypting any record if the keyset does not match, i.e. it was swapped. i.e. \"sha256:3b4c...\"")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY', or 'pkcs11://<module path>?token=<token label>&object=<key label>' for a key in a PKCS#11 token, with the PIN in PKCS11_PIN, when built with -tags pkcs11. For a multi-wrapped keyset, a comma-separated list of the master keys to try first.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.auditLog, "audit-log", os.Getenv("ENCRYPTER_AUDIT_LOG"), "Audit log to append a record of the run to, with the user, host, digests of the input and output, columns and key IDs, chained by hash to the previous records. Defaults to the ENCRYPTER_AUDIT_LOG environment variable. Check it with verify-audit-log. i.e. \"/var/log/encrypter/audit.jsonl\"")
+	flag.StringVar(&c.keyLedger, "key-usage-ledger", "", "Filename of a ledger counting the messages encrypted by each key ID, kept across runs. Keys are checked against -key-max-messages and -key-max-age-days. Disabled when empty.")
+	flag.Uint64Var(&c.keyMaxMessages, "key-max-messages", ledger.DefaultMaxMessages, "Number of messages a key may encrypt before it must be rotated, 0 for no limit. The default is the AES-GCM bound for random nonces.")
+	flag.IntVar(&c.keyMaxAgeDays, "key-max-age-days", 365, "Age in days past which a key must be rotated, 0 for no limit. A key is dated by the modification time of its keyset file when first recorded in the ledger.")
+	flag.StringVar(&c.keyLimitAction, "key-limit-action", "warn", "What to do when a key exceeds -key-max-messages or -key-max-age-days: warn, or refuse to encrypt.")
+	flag.StringVar(&c.compress, "compress", "", "Compression of the output file: gzip, zstd or none. Inferred from the output filename extension (.gz, .zst) when not set. Compressed input is always detected automatically.")
+	flag.Parse()
+	if c.record == "" {
+		log.Fatal("Record element path is missing.")
+	}
+	if c.columns == "" {
+		log.Fatal("columns flag is missing. Please set columns flag that is a comma-separated list of [name=]path. i.e. -columns \"Card_Number=Number,Issuing_Bank=@bank\"")
+	}
+	if c.fields == "" {
+		log.Fatal("fields flag is missing. Please set fields flag that is a comma-separated list of column names that need to be encrypted. i.e. -fields \"Card_Number,Card_Holders_Name\"")
+	}
+	if c.keyset == "" {
+		log.Fatal("Keyset filename to be used to encrypt the data is missing.")
+	}
+	if c.keyLimitAction != "warn" && c.keyLimitAction != "refuse" {
+		log.Fatalf("invalid key-limit-action %q, use warn or refuse.", c.keyLimitAction)
+	}
+	if c.masterKeyURI == "" {
+		log.Fatal("URI of the master key is missing.")
+	}
+	if c.in == "" {
+		log.Fatal("Input xml filename is missing.")
+	}
+	if c.out == "" {
+		log.Fatal("Output filename is missing.")
+	}
+	return c
+}
+
+func setupKeyset(ctx context.Context, c genCfg) {
+	var err error
+	if c.keyLedger != "" {
+		usage, err = ledger.Open(c.keyLedger, ledger.Policy{
+			MaxMessages: c.keyMaxMessages,
+			MaxAge:      time.Duration(c.keyMaxAgeDays) * 24 * time.Hour,
+			Refuse:      c.keyLimitAction == "refuse",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keyHandle, err := keys.VerifyKeyset(ctx, c.keyset, c.masterKeyURI, keys.Expect{KeyType: "AES256_GCM", Fingerprint: c.keysetFingerprint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if usage != nil {
+		k, err := keys.FetchKeyset(ctx, c.keyset)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := usage.Check(keyHandle.KeysetInfo().GetPrimaryKeyId(), k.Created); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	encrypter, err = aead.New(keyHandle)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func encryptData(data string) string {
+	dataInBytes := []byte(data)
+	encryptionContext := []byte("")
+
+	encryptedData, err := encrypter.Encrypt(dataInBytes, encryptionContext)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auditRun != nil {
+		auditRun.Encrypted(encryptedData)
+	}
+	if usage != nil {
+		if err := usage.Record(encryptedData); err != nil {
+			// Keep the counts of the messages encrypted so far.
+			if err := usage.Save(); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(encryptedData)
+}
+
+// parseColumns parses the columns flag. Without a name, a column is named
+// after the last step of its path.
+func parseColumns(spec string) ([]column, error) {
+	var columns []column
+	for _, item := range strings.Split(spec, ",") {
+		name, path, hasName := strings.Cut(strings.TrimSpace(item), "=")
+		if !hasName {
+			path = name
+		}
+
+		var steps []string
+		for _, step := range strings.Split(strings.Trim(path, "/"), "/") {
+			if step == "" || step == "." || step == "text()" {
+				continue
+			}
+			steps = append(steps, step)
+		}
+		for i, step := range steps {
+			if strings.HasPrefix(step, "@") && i != len(steps)-1 {
+				return nil, fmt.Errorf("column %q: an attribute must be the last step of the path", item)
+			}
+		}
+
+		if !hasName {
+			if len(steps) == 0 {
+				return nil, fmt.Errorf("column %q needs a name", item)
+			}
+			name, _, _ = strings.Cut(strings.TrimPrefix(steps[len(steps)-1], "@"), "[")
+		}
+		columns = append(columns, column{name: strings.TrimSpace(name), path: steps})
+	}
+	return columns, nil
+}
+
+// localName strips a namespace prefix from a path step.
+func localName(step string) string {
+	if i := strings.LastIndex(step, ":"); i >= 0 {
+		return step[i+1:]
+	}
+	return step
+}
+
+// find evaluates a path relative to n and returns the trimmed text of the first match.
+func find(n *node, path []string) string {
+	if len(path) == 0 {
+		return strings.TrimSpace(n.Text)
+	}
+
+	step := path[0]
+	if strings.HasPrefix(step, "@") {
+		name := localName(step[1:])
+		for _, attr := range n.Attrs {
+			if attr.Name.Local == name {
+				return attr.Value
+			}
+		}
+		return ""
+	}
+
+	index := 0
+	if open := strings.Index(step, "["); open >= 0 && strings.HasSuffix(step, "]") {
+		index, _ = strconv.Atoi(step[open+1 : len(step)-1])
+		step = step[:open]
+	}
+	name := localName(step)
+
+	seen := 0
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		if name != "*" && child.XMLName.Local != name {
+			continue
+		}
+		seen++
+		if index > 0 && seen != index {
+			continue
+		}
+		if value := find(child, path[1:]); value != "" || index > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// recordMatcher reports whether the element stack is at a record element.
+type recordMatcher struct {
+	anyDepth bool
+	path     []string
+}
+
+func newRecordMatcher(record string) recordMatcher {
+	if strings.HasPrefix(record, "/") && !strings.HasPrefix(record, "//") {
+		var path []string
+		for _, step := range strings.Split(strings.Trim(record, "/"), "/") {
+			path = append(path, localName(step))
+		}
+		return recordMatcher{path: path}
+	}
+	return recordMatcher{anyDepth: true, path: []string{localName(strings.TrimLeft(record, "/"))}}
+}
+
+func (m recordMatcher) match(stack []string) bool {
+	if m.anyDepth {
+		return stack[len(stack)-1] == m.path[0]
+	}
+	if len(stack) != len(m.path) {
+		return false
+	}
+	for i := range stack {
+		if stack[i] != m.path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func main() {
+	cfg := parseFlags()
+	ctx := context.Background()
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.auditLog != "" {
+		auditRun = audit.Start("xml-encrypter", "encrypt")
+		auditRun.Input.Name, auditRun.Output.Name = cfg.in, cfg.out
+		auditRun.Columns = strings.Split(cfg.fields, ",")
+		auditRun.MasterKeyURI = cfg.masterKeyURI
+	}
+	setupKeyset(ctx, cfg)
+
+	columns, err := parseColumns(cfg.columns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+
+	fieldsToEncrypt := make(map[string]int)
+	for _, val := range strings.Split(cfg.fields, ",") {
+		fieldsToEncrypt[strings.ToLower(val)] = 0
+	}
+	columnsToEncrypt := make(map[int]int)
+	for index, name := range names {
+		if _, hasKeyInMap := fieldsToEncrypt[strings.ToLower(name)]; hasKeyInMap {
+			columnsToEncrypt[index] = 0
+		}
+	}
+
+	in, err := compress.Open(cfg.in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := compress.Create(cfg.out, cfg.compress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outWriter, err := output.NewWriter(out, cfg.outFormat, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	decoder := xml.NewDecoder(in)
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return charset.NewReader(input, label)
+	}
+	matcher := newRecordMatcher(cfg.record)
+
+	var stack []string
+	count := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			if !matcher.match(stack) {
+				continue
+			}
+
+			// The whole record element is consumed, including its end element.
+			var record node
+			if err := decoder.DecodeElement(&record, &t); err != nil {
+				log.Fatal(err)
+			}
+			stack = stack[:len(stack)-1]
+			count++
+
+			values := make([]string, len(columns))
+			for i, c := range columns {
+				values[i] = find(&record, c.path)
+			}
+			for colToEncryptIndex := range columnsToEncrypt {
+				values[colToEncryptIndex] = encryptData(values[colToEncryptIndex])
+			}
+
+			if err := outWriter.Write(values); err != nil {
+				log.Fatal(err)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if count == 0 {
+		log.Printf("No %q records found in %s", cfg.record, cfg.in)
+	}
+	if err := outWriter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	// Closing the output flushes any pending compressed data.
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if usage != nil {
+		if err := usage.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if auditRun != nil {
+		if err := audit.Append(cfg.auditLog, auditRun); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
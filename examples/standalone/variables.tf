@@ -141,3 +141,20 @@ variable "access_level_ip_subnetworks" {
   type        = list(string)
   default     = []
 }
+
+variable "blind_index_fields" {
+  description = "Fields of the example data to add a blind index column to, i.e. [\"Card_Number\"], so rows can be looked up by equality without decrypting the field. Creates an HMAC_SHA256_PRF keyset for the blind index. If empty, no blind index column is added."
+  type        = list(string)
+  default     = []
+}
+
+variable "pad_fields" {
+  description = "Encrypted fields of the example data to pad to fixed length buckets before encryption, so the ciphertext length does not reveal the plaintext length, and their bucket sizes in bytes, i.e. {Card_Holders_Name = [32, 64], Card_PIN = [8]}. The decrypted view strips the padding of these fields. If empty, no field is padded."
+  type        = map(list(number))
+  default     = {}
+
+  validation {
+    condition     = alltrue([for field, buckets in var.pad_fields : contains(["Card_Number", "Card_Holders_Name", "CVV_CVV2", "Expiry_Date", "Card_PIN", "Credit_Limit"], field) && length(buckets) > 0])
+    error_message = "Padded fields must be encrypted fields of the example data, with at least one bucket size."
+  }
+}
@@ -42,7 +42,7 @@ locals {
   taxonomy_name            = "secured_taxonomy"
   taxonomy_display_name    = "${local.taxonomy_name}-${random_string.suffix.result}"
   csv_load_job_id          = "job_load_csv_${random_string.suffix.result}"
-  bq_schema                = "Card_Type_Code:STRING, Card_Type_Full_Name:STRING, Issuing_Bank:STRING, Card_Number:STRING, Card_Holders_Name:STRING, CVV_CVV2:STRING, Issue_Date:STRING, Expiry_Date:STRING, Billing_Date:STRING, Card_PIN:STRING, Credit_Limit:STRING"
+  bq_schema                = "Card_Type_Code:STRING, Card_Type_Full_Name:STRING, Issuing_Bank:STRING, Card_Number:STRING, Card_Holders_Name:STRING, CVV_CVV2:STRING, Issue_Date:STRING, Expiry_Date:STRING, Billing_Date:STRING, Card_PIN:STRING, Credit_Limit:STRING${join("", [for field in local.blind_index_fields : ", ${field}_bidx:STRING"])}"
   kek_keyring              = "kek_keyring_${random_string.suffix.result}"
   kek_key_name             = "kek_key_${random_string.suffix.result}"
   kek_users                = "serviceAccount:${var.terraform_service_account},group:${var.plaintext_reader_group}"
@@ -50,6 +50,9 @@ locals {
   encrypters               = [local.kek_users]
   decrypters               = [local.kek_users]
   keyset_file              = "keyset_${random_string.suffix.result}.json"
+  blind_index_keyset_file  = "blind_index_keyset_${random_string.suffix.result}.json"
+  blind_index_fields       = var.blind_index_fields
+  pad_fields               = join(",", [for field, buckets in var.pad_fields : join(":", concat([field], buckets))])
   encrypted_data_csv_file  = "encrypted_${random_string.suffix.result}.csv"
   encrypted_data_json_file = "encrypted_${random_string.suffix.result}.json"
   tags                     = ["vpc-connector"]
@@ -196,6 +199,7 @@ resource "google_bigquery_table" "credit_card" {
       pt_credit_limit        = google_data_catalog_policy_tag.sensitive_tags["credit_limit"].id
       pt_card_type_full_name = google_data_catalog_policy_tag.sensitive_tags["card_type_full_name"].id
       pt_card_type_code      = google_data_catalog_policy_tag.sensitive_tags["card_type_code"].id
+      blind_index_fields     = local.blind_index_fields
     }
   )
 
@@ -268,13 +272,40 @@ EOF
   ]
 }
 
+/**
+* Blind index key
+*
+* PRF key used to compute the blind index columns of local.blind_index_fields,
+* so rows can be looked up by equality without decrypting the column. Use the
+* blind-index helper to compute the blind index of a value to look up.
+* Only created when var.blind_index_fields is set.
+*/
+resource "null_resource" "create_blind_index_key" {
+  count = length(local.blind_index_fields) > 0 ? 1 : 0
+
+  provisioner "local-exec" {
+    command = <<EOF
+    tinkey create-keyset \
+    --key-template HMAC_SHA256_PRF \
+    --out-format json --out ${path.module}/${local.blind_index_keyset_file} \
+    --master-key-uri "gcp-kms://${module.kek_wrapping_key.keys[local.kek_key_name]}"
+EOF
+  }
+
+  depends_on = [
+    google_project_iam_binding.remove_owner_role,
+    module.kek_wrapping_key
+  ]
+}
+
 data "external" "dek_wrapped_key" {
   program = [
     "/bin/bash", "${path.module}/helpers/read_key.sh"
   ]
 
   query = {
-    key_file = "${abspath(path.module)}/${local.keyset_file}"
+    key_file       = "${abspath(path.module)}/${local.keyset_file}"
+    master_key_uri = "gcp-kms://${module.kek_wrapping_key.keys[local.kek_key_name]}"
   }
 
   depends_on = [
@@ -290,6 +321,14 @@ resource "null_resource" "encrypt_csv" {
       --in "${abspath(path.module)}/assets/cc_10000_records.csv" \
       --out "${abspath(path.module)}/${local.encrypted_data_csv_file}" \
       --fields "Card_Number,Card_Holders_Name,CVV_CVV2,Expiry_Date,Card_PIN,Credit_Limit" \
+      --pii-allow "Issue_Date:DATE" \
+%{ if length(local.blind_index_fields) > 0 ~}
+      --blind-index-fields "${join(",", local.blind_index_fields)}" \
+      --blind-index-keyset ${abspath(path.module)}/${local.blind_index_keyset_file} \
+%{ endif ~}
+%{ if local.pad_fields != "" ~}
+      --pad-fields "${local.pad_fields}" \
+%{ endif ~}
       --keyset ${abspath(path.module)}/${local.keyset_file} \
       --master-key-uri "gcp-kms://${module.kek_wrapping_key.keys[local.kek_key_name]}"
 EOF
@@ -298,7 +337,8 @@ EOF
   depends_on = [
     google_project_iam_binding.remove_owner_role,
     module.kek_wrapping_key,
-    null_resource.create_wrapped_key
+    null_resource.create_wrapped_key,
+    null_resource.create_blind_index_key
   ]
 }
 
@@ -394,14 +434,6 @@ resource "google_bigquery_routine" "decrypt_function" {
   ]
 }
 
-data "template_file" "decrypted_view" {
-  template = file("${path.module}/templates/decrypted_view.template")
-  vars = {
-    decrypt_function = "${local.dataset_id}.${local.decrypt_function_id}"
-    full_table_id    = "${module.harness_projects.data_project_id}.${local.dataset_id}.${local.table_id}"
-  }
-}
-
 resource "google_bigquery_table_iam_member" "encrypted_credit_card_data_viewer" {
   project    = module.harness_projects.data_project_id
   dataset_id = local.dataset_id
@@ -425,7 +457,14 @@ resource "google_bigquery_table" "credit_card_decrypted_view" {
   deletion_protection = false
 
   view {
-    query          = data.template_file.decrypted_view.rendered
+    query = templatefile("${path.module}/templates/decrypted_view.template",
+      {
+        decrypt_function   = "${local.dataset_id}.${local.decrypt_function_id}"
+        full_table_id      = "${module.harness_projects.data_project_id}.${local.dataset_id}.${local.table_id}"
+        blind_index_fields = local.blind_index_fields
+        pad_fields         = keys(var.pad_fields)
+      }
+    )
     use_legacy_sql = false
   }
 
@@ -505,7 +544,7 @@ module "pubsub_to_bigquery" {
     name       = "pubsub_to_bigquery_schema"
     type       = "AVRO"
     encoding   = "JSON"
-    definition = file("${path.module}/templates/avro.schema.template")
+    definition = templatefile("${path.module}/templates/avro.schema.template", { blind_index_fields = local.blind_index_fields })
   }
 
   bigquery_subscriptions = [
@@ -530,6 +569,14 @@ resource "null_resource" "encrypt_json" {
       --in "${abspath(path.module)}/assets/cc_100_records.json" \
       --out "${abspath(path.module)}/${local.encrypted_data_json_file}" \
       --fields "Card_Number,Card_Holders_Name,CVV_CVV2,Expiry_Date,Card_PIN,Credit_Limit" \
+      --pii-allow "Issue_Date:DATE" \
+%{ if length(local.blind_index_fields) > 0 ~}
+      --blind-index-fields "${join(",", local.blind_index_fields)}" \
+      --blind-index-keyset ${abspath(path.module)}/${local.blind_index_keyset_file} \
+%{ endif ~}
+%{ if local.pad_fields != "" ~}
+      --pad-fields "${local.pad_fields}" \
+%{ endif ~}
       --keyset ${abspath(path.module)}/${local.keyset_file} \
       --master-key-uri "gcp-kms://${module.kek_wrapping_key.keys[local.kek_key_name]}"
     EOF
@@ -538,7 +585,8 @@ resource "null_resource" "encrypt_json" {
   depends_on = [
     google_project_iam_binding.remove_owner_role,
     module.kek_wrapping_key,
-    null_resource.create_wrapped_key
+    null_resource.create_wrapped_key,
+    null_resource.create_blind_index_key
   ]
 }
 
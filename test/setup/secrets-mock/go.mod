@@ -0,0 +1,3 @@
+module secrets-mock
+
+go 1.23.0
@@ -0,0 +1,160 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// secrets-mock serves keysets from a directory like Secret Manager and Vault
+// KV version 2, to test the gcp-secretmanager:// and vault-kv:// keyset
+// sources without credentials. Each version of a secret is a file named after
+// its version number, its modification time being the creation time:
+//
+//	<dir>/projects/<project>/secrets/<secret>/<version>
+//	<dir>/<mount>/<path>/<version>
+//
+// Run the encrypters with SECRETMANAGER_EMULATOR_HOST=<addr>, or with
+// VAULT_ADDR=http://<addr> and any VAULT_TOKEN.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mock config
+type mockCfg struct {
+	addr       string
+	dir        string
+	accessOnly bool
+}
+
+func parseFlags() mockCfg {
+	var c mockCfg
+	flag.StringVar(&c.addr, "addr", "localhost:8200", "Address to listen on.")
+	flag.StringVar(&c.dir, "dir", ".", "Directory of the secrets, a file per version.")
+	flag.BoolVar(&c.accessOnly, "access-only", false, "Deny reading Secret Manager version metadata, like an identity with roles/secretmanager.secretAccessor alone.")
+	flag.Parse()
+	return c
+}
+
+// version returns the file of a version, the highest one for latest.
+func version(dir, v string) (string, int, error) {
+	if v != "" && v != "latest" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid version %q", v)
+		}
+		return filepath.Join(dir, v), n, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", 0, err
+	}
+	var versions []int
+	for _, e := range entries {
+		if n, err := strconv.Atoi(e.Name()); err == nil {
+			versions = append(versions, n)
+		}
+	}
+	if len(versions) == 0 {
+		return "", 0, fmt.Errorf("%s has no versions", dir)
+	}
+	sort.Ints(versions)
+	n := versions[len(versions)-1]
+	return filepath.Join(dir, strconv.Itoa(n)), n, nil
+}
+
+func read(w http.ResponseWriter, dir, v string) ([]byte, int, time.Time, bool) {
+	name, n, err := version(dir, v)
+	if err == nil {
+		var b []byte
+		if b, err = os.ReadFile(name); err == nil {
+			info, _ := os.Stat(name)
+			return b, n, info.ModTime().UTC(), true
+		}
+	}
+	http.Error(w, err.Error(), http.StatusNotFound)
+	return nil, 0, time.Time{}, false
+}
+
+func reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+// secretManager serves /v1/projects/P/secrets/S/versions/V[:access].
+func secretManager(c mockCfg, w http.ResponseWriter, r *http.Request) {
+	name, access := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/"), ":access")
+	secret, v, ok := strings.Cut(name, "/versions/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	b, n, created, ok := read(w, filepath.Join(c.dir, filepath.FromSlash(secret)), v)
+	if !ok {
+		return
+	}
+	name = fmt.Sprintf("%s/versions/%d", secret, n)
+	if access {
+		reply(w, map[string]any{"name": name, "payload": map[string]any{"data": b}})
+		return
+	}
+	if c.accessOnly {
+		http.Error(w, `{"error":{"code":403,"status":"PERMISSION_DENIED"}}`, http.StatusForbidden)
+		return
+	}
+	reply(w, map[string]any{"name": name, "createTime": created, "state": "ENABLED"})
+}
+
+// vault serves /v1/<mount>/data/<path>?version=V.
+func vault(c mockCfg, w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") == "" {
+		http.Error(w, `{"errors":["missing client token"]}`, http.StatusForbidden)
+		return
+	}
+	mount, secret, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/"), "/data/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	b, n, created, ok := read(w, filepath.Join(c.dir, mount, filepath.FromSlash(secret)), r.URL.Query().Get("version"))
+	if !ok {
+		return
+	}
+	reply(w, map[string]any{"data": map[string]any{
+		"data":     map[string]string{"keyset": string(b)},
+		"metadata": map[string]any{"created_time": created, "version": n},
+	}})
+}
+
+func main() {
+	c := parseFlags()
+	http.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL)
+		if strings.HasPrefix(r.URL.Path, "/v1/projects/") {
+			secretManager(c, w, r)
+		} else {
+			vault(c, w, r)
+		}
+	})
+	log.Fatal(http.ListenAndServe(c.addr, nil))
+}
@@ -0,0 +1,41 @@
+#!/bin/bash
+
+# Copyright 2026 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+# Creates a SoftHSM token holding an AES-256 key, to test pkcs11:// master keys
+# locally, and prints the master key URI. Needs softhsm2 and opensc
+# (pkcs11-tool), and tinkey-mock and the encrypters built with -tags pkcs11.
+#
+#   export SOFTHSM2_CONF=$(mktemp -d)/softhsm2.conf PKCS11_PIN=1234
+#   uri=$(./softhsm-kek.sh)
+#   tinkey-mock --key-template AES256_GCM --out keyset.json --master-key-uri "$uri"
+
+set -e
+set -u
+
+module=${SOFTHSM2_MODULE:-/usr/lib/softhsm/libsofthsm2.so}
+token=${SOFTHSM2_TOKEN:-onprem}
+object=${SOFTHSM2_OBJECT:-kek}
+
+tokens_dir=$(dirname "$SOFTHSM2_CONF")/tokens
+mkdir -p "$tokens_dir"
+echo "directories.tokendir = $tokens_dir" > "$SOFTHSM2_CONF"
+
+softhsm2-util --init-token --free --label "$token" --pin "$PKCS11_PIN" --so-pin "$PKCS11_PIN" > /dev/null
+pkcs11-tool --module "$module" --token-label "$token" --login --pin "$PKCS11_PIN" \
+  --keygen --key-type AES:32 --label "$object" > /dev/null
+
+echo "pkcs11://$module?token=$token&object=$object"
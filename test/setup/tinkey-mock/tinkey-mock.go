@@ -16,18 +16,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"log"
 	"os"
 	"strings"
 
-	"github.com/tink-crypto/tink-go-gcpkms/v2/integration/gcpkms"
 	"github.com/tink-crypto/tink-go/v2/aead"
-	"github.com/tink-crypto/tink-go/v2/core/registry"
 	"github.com/tink-crypto/tink-go/v2/daead"
 	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/prf"
 	tinkpb "github.com/tink-crypto/tink-go/v2/proto/tink_go_proto"
+
+	"encrypter-common/keys"
 )
 
 // generator config
@@ -36,14 +38,17 @@ type keyCfg struct {
 	out          string
 	outFormat    string
 	masterKeyURI string
+	credentials  keys.Credentials
 }
 
 func parseFlags() keyCfg {
 	var c keyCfg
-	flag.StringVar(&c.keyTemplate, "key-template", "", "The key template name: AES256_GCM or AES256_SIV.")
+	flag.StringVar(&c.keyTemplate, "key-template", "", "The key template name: AES256_GCM, AES256_SIV or HMAC_SHA256_PRF.")
 	flag.StringVar(&c.out, "out", "", "The output filename, must not exist, to write the keyset to.")
 	flag.StringVar(&c.outFormat, "out-format", "json", "The output format: json or binary (case-insensitive). json is default")
-	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY'.")
+	flag.StringVar(&c.masterKeyURI, "master-key-uri", "", "URI of the master key. Format: 'gcp-kms://projects/PROJECT_ID/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY'. A comma-separated list writes the keyset wrapped with each master key. A pkcs11://<module path>?token=<token label>&object=<key label> URI wraps it with an AES key in a PKCS#11 token, with the PIN in the PKCS11_PIN environment variable; build with -tags pkcs11.")
+	keys.RegisterCredentialFlags(flag.CommandLine, &c.credentials)
+	flag.StringVar(&c.credentials.File, "credential", "", "Same as -credentials-file, the name of the flag in tinkey.")
 	flag.Parse()
 	if c.masterKeyURI == "" {
 		log.Fatal("URI of the master key is missing.")
@@ -63,6 +68,8 @@ func getKeyTemplate(keyTemplate string) (*tinkpb.KeyTemplate, error) {
 		return aead.AES128GCMKeyTemplate(), nil
 	case "AES256_SIV":
 		return daead.AESSIVKeyTemplate(), nil
+	case "HMAC_SHA256_PRF":
+		return prf.HMACSHA256PRFKeyTemplate(), nil
 	default:
 		return nil, errors.New("invalid key template option")
 	}
@@ -79,6 +86,26 @@ func getKeyWriter(outFormat string, f *os.File) (keyset.Writer, error) {
 	}
 }
 
+// writeMultiWrapped writes the keyset wrapped with each master key, so it can
+// be unwrapped with whichever master key is reachable.
+func writeMultiWrapped(ctx context.Context, keyHandle *keyset.Handle, masterKeyURIs []string, outFormat string, f *os.File) error {
+	if strings.ToUpper(outFormat) != "JSON" {
+		return errors.New("keysets wrapped with several master keys are only written in json")
+	}
+	m := &keys.MultiWrapped{}
+	for _, uri := range masterKeyURIs {
+		if err := m.Add(ctx, keyHandle, uri); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
 func main() {
 	cfg := parseFlags()
 	var err error
@@ -94,16 +121,8 @@ func main() {
 	}
 	defer f.Close()
 
-	// load master key
 	ctx := context.Background()
-	gcpClient, err := gcpkms.NewClientWithOptions(ctx, cfg.masterKeyURI)
-	if err != nil {
-		log.Fatal(err)
-	}
-	registry.RegisterKMSClient(gcpClient)
-
-	masterKey, err := gcpClient.GetAEAD(cfg.masterKeyURI)
-	if err != nil {
+	if err := keys.SetCredentials(ctx, cfg.credentials); err != nil {
 		log.Fatal(err)
 	}
 
@@ -117,6 +136,19 @@ func main() {
 		log.Fatal(err)
 	}
 
+	masterKeyURIs := strings.Split(cfg.masterKeyURI, ",")
+	if len(masterKeyURIs) > 1 {
+		if err := writeMultiWrapped(ctx, keyHandle, masterKeyURIs, cfg.outFormat, f); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	masterKey, err := keys.LoadMasterKey(ctx, cfg.masterKeyURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	keyWriter, err := getKeyWriter(cfg.outFormat, f)
 	if err != nil {
 		log.Fatal(err)